@@ -0,0 +1,68 @@
+package avrocodec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   types.SourceCoords
+		want types.SourceCoords
+	}{
+		{"core fields only", types.SourceCoords{OBUID: 1, Lat: 1.5, Lon: -2}, types.SourceCoords{OBUID: 1, Lat: 1.5, Lon: -2}},
+		{
+			"every field",
+			types.SourceCoords{OBUID: 42, Lat: 48.8583701, Lon: 2.2944813, Timestamp: time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC), FwVersion: "2.1.0", Seq: 9},
+			types.SourceCoords{OBUID: 42, Lat: 48.8583701, Lon: 2.2944813, Timestamp: time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC), FwVersion: "2.1.0", Seq: 9},
+		},
+		{
+			"timestamp kept to the microsecond",
+			types.SourceCoords{OBUID: 1, Timestamp: time.Date(2024, 5, 1, 13, 0, 0, 123456789, time.UTC)},
+			types.SourceCoords{OBUID: 1, Timestamp: time.Date(2024, 5, 1, 13, 0, 0, 123456000, time.UTC)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A mock:// URL keeps the registry in memory. The mock only
+			// serves the ID of a schema's first registration, so each case
+			// gets its own.
+			c, err := New("mock://", "gps-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := c.Encode(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The registry's wire format: magic byte 0, then the schema ID.
+			if len(b) < 5 || b[0] != 0 {
+				t.Fatalf("record %x lacks the schema registry header", b)
+			}
+			got, err := c.Decode(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Timestamp.Equal(tt.want.Timestamp) {
+				t.Errorf("timestamp = %v, want %v", got.Timestamp, tt.want.Timestamp)
+			}
+			got.Timestamp = tt.want.Timestamp
+			if got != tt.want {
+				t.Errorf("Decode(Encode(%+v)) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeRejectsForeignRecords(t *testing.T) {
+	c, err := New("mock://", "gps-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Decode([]byte(`{"obuid":1,"lat":1,"lon":2}`)); err == nil {
+		t.Error("JSON record decoded as Avro")
+	}
+}
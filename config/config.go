@@ -0,0 +1,115 @@
+// Package config fills a service's flags from several sources. Each flag is
+// resolved with this precedence, highest first:
+//
+//  1. the command line, e.g. -max-speed=40
+//  2. the environment, as PREFIX_FLAG_NAME, e.g. RECEIVER_MAX_SPEED=40
+//  3. a JSON config file named by -config or PREFIX_CONFIG, e.g.
+//     {"max-speed": 40}
+//  4. the flag's default
+//
+// Every service defines its settings as flags, so the flag set doubles as the
+// one schema all sources are validated against.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Load parses args into fs and then fills every flag not given on the
+// command line from the environment or the config file. Unknown keys in the
+// file and values that don't parse are errors.
+func Load(fs *flag.FlagSet, prefix string, args []string) error {
+	if fs.Lookup("config") == nil {
+		fs.String("config", "", "JSON file of flag values, overridden by the environment and command line")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	path := fs.Lookup("config").Value.String()
+	if !explicit["config"] {
+		if v, ok := os.LookupEnv(EnvName(prefix, "config")); ok {
+			path = v
+		}
+	}
+	fromFile := map[string]string{}
+	if path != "" {
+		var err error
+		if fromFile, err = readFile(path); err != nil {
+			return err
+		}
+		for name := range fromFile {
+			if fs.Lookup(name) == nil {
+				return fmt.Errorf("%s: unknown setting %q", path, name)
+			}
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] || f.Name == "config" {
+			return
+		}
+		env := EnvName(prefix, f.Name)
+		if v, ok := os.LookupEnv(env); ok {
+			if serr := fs.Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("%s: %w", env, serr)
+			}
+			return
+		}
+		if v, ok := fromFile[f.Name]; ok {
+			if serr := fs.Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("%s: %s: %w", path, f.Name, serr)
+			}
+		}
+	})
+	return err
+}
+
+// MustLoad is Load on the process's command line that exits on error.
+func MustLoad(prefix string) {
+	if err := Load(flag.CommandLine, prefix, os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// EnvName returns the environment variable consulted for a flag, e.g.
+// EnvName("RECEIVER", "max-speed") is RECEIVER_MAX_SPEED.
+func EnvName(prefix, flagName string) string {
+	return prefix + "_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// readFile reads a flat JSON object into flag-ready strings. Numbers keep
+// their literal text so integers like 1000000 don't turn into 1e+06.
+func readFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var raw map[string]any
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	out := make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch v := v.(type) {
+		case string:
+			out[k] = v
+		case json.Number, bool:
+			out[k] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("%s: %s must be a string, number or boolean", path, k)
+		}
+	}
+	return out, nil
+}
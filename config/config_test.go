@@ -0,0 +1,78 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{"default", ``, nil, nil, "10", false},
+		{"file", `{"max-speed": 20}`, nil, nil, "20", false},
+		{"env over file", `{"max-speed": 20}`, map[string]string{"TEST_MAX_SPEED": "30"}, nil, "30", false},
+		{"command line over env", `{"max-speed": 20}`, map[string]string{"TEST_MAX_SPEED": "30"}, []string{"-max-speed=40"}, "40", false},
+		{"command line over file", `{"max-speed": 20}`, nil, []string{"-max-speed=40"}, "40", false},
+		{"large number", `{"max-speed": 1000000}`, nil, nil, "1000000", false},
+		{"unknown setting", `{"max-sped": 20}`, nil, nil, "", true},
+		{"bad file value", `{"max-speed": "fast"}`, nil, nil, "", true},
+		{"bad env value", ``, map[string]string{"TEST_MAX_SPEED": "fast"}, nil, "", true},
+		{"nested value", `{"max-speed": {"value": 20}}`, nil, nil, "", true},
+		{"malformed file", `{"max-speed": `, nil, nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "config.json")
+				if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				args = append([]string{"-config", path}, args...)
+			}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			maxSpeed := fs.Int("max-speed", 10, "")
+			err := Load(fs, "TEST", args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && fs.Lookup("max-speed").Value.String() != tt.want {
+				t.Errorf("max-speed = %d, want %s", *maxSpeed, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigPathFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"topic": "from-file", "verbose": true}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_CONFIG", path)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	topic := fs.String("topic", "gps", "")
+	verbose := fs.Bool("verbose", false, "")
+	if err := Load(fs, "TEST", nil); err != nil {
+		t.Fatal(err)
+	}
+	if *topic != "from-file" || !*verbose {
+		t.Errorf("got topic %q and verbose %v, want from-file and true", *topic, *verbose)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if got := EnvName("RECEIVER", "max-speed"); got != "RECEIVER_MAX_SPEED" {
+		t.Errorf("EnvName = %s, want RECEIVER_MAX_SPEED", got)
+	}
+}
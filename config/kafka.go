@@ -0,0 +1,90 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/erastusk/gpscords/avrocodec"
+	"github.com/erastusk/gpscords/types"
+)
+
+// KafkaSecurity holds what the services need to reach a secured Kafka
+// cluster. The zero value is a plaintext connection.
+type KafkaSecurity struct {
+	Protocol      string // security.protocol, e.g. SASL_SSL
+	SASLMechanism string // sasl.mechanism, e.g. PLAIN or SCRAM-SHA-512
+	SASLUsername  string
+	SASLPassword  string
+	CALocation    string // CA certificate file for verifying the brokers
+	CertLocation  string // client certificate file, for mutual TLS
+	KeyLocation   string // client key file, for mutual TLS
+}
+
+// KafkaSecurityFlags defines the -kafka-security-protocol, -kafka-sasl-* and
+// -kafka-ssl-* flags on fs, which fill s when fs is loaded. Each defaults to
+// the KAFKA_* variable earlier releases read, e.g. KAFKA_SASL_USERNAME, so
+// existing deployments keep working.
+func KafkaSecurityFlags(fs *flag.FlagSet, s *KafkaSecurity) {
+	fs.StringVar(&s.Protocol, "kafka-security-protocol", os.Getenv("KAFKA_SECURITY_PROTOCOL"), "Kafka security.protocol, e.g. SASL_SSL (empty is plaintext)")
+	fs.StringVar(&s.SASLMechanism, "kafka-sasl-mechanism", os.Getenv("KAFKA_SASL_MECHANISM"), "Kafka sasl.mechanism, e.g. PLAIN or SCRAM-SHA-512")
+	fs.StringVar(&s.SASLUsername, "kafka-sasl-username", os.Getenv("KAFKA_SASL_USERNAME"), "Kafka SASL username")
+	fs.StringVar(&s.SASLPassword, "kafka-sasl-password", os.Getenv("KAFKA_SASL_PASSWORD"), "Kafka SASL password")
+	fs.StringVar(&s.CALocation, "kafka-ssl-ca-location", os.Getenv("KAFKA_SSL_CA_LOCATION"), "CA certificate file for verifying the Kafka brokers")
+	fs.StringVar(&s.CertLocation, "kafka-ssl-cert-location", os.Getenv("KAFKA_SSL_CERT_LOCATION"), "client certificate file for mutual TLS with Kafka")
+	fs.StringVar(&s.KeyLocation, "kafka-ssl-key-location", os.Getenv("KAFKA_SSL_KEY_LOCATION"), "client key file for mutual TLS with Kafka")
+}
+
+// Properties returns the librdkafka properties for s. Empty fields are left
+// out, so the zero value adds nothing.
+func (s KafkaSecurity) Properties() map[string]string {
+	props := map[string]string{}
+	for key, v := range map[string]string{
+		"security.protocol":        s.Protocol,
+		"sasl.mechanism":           s.SASLMechanism,
+		"sasl.username":            s.SASLUsername,
+		"sasl.password":            s.SASLPassword,
+		"ssl.ca.location":          s.CALocation,
+		"ssl.certificate.location": s.CertLocation,
+		"ssl.key.location":         s.KeyLocation,
+	} {
+		if v != "" {
+			props[key] = v
+		}
+	}
+	return props
+}
+
+// Serialization selects the codec of record values.
+type Serialization struct {
+	Format      string // json, the default, protobuf or avro
+	RegistryURL string // schema registry, needed by avro
+}
+
+// SerializationFlags defines the -kafka-serialization and
+// -kafka-schema-registry-url flags on fs, which fill the returned
+// Serialization when fs is loaded. They default to KAFKA_SERIALIZATION and
+// KAFKA_SCHEMA_REGISTRY_URL.
+func SerializationFlags(fs *flag.FlagSet) *Serialization {
+	s := &Serialization{}
+	fs.StringVar(&s.Format, "kafka-serialization", os.Getenv("KAFKA_SERIALIZATION"), "record value format: json, protobuf or avro (default json)")
+	fs.StringVar(&s.RegistryURL, "kafka-schema-registry-url", os.Getenv("KAFKA_SCHEMA_REGISTRY_URL"), "schema registry URL, needed by the avro serialization")
+	return s
+}
+
+// Codec returns the codec s selects for topic.
+func (s Serialization) Codec(topic string) (types.Codec, error) {
+	switch s.Format {
+	case "", "json":
+		return types.JSONCodec{}, nil
+	case "protobuf":
+		return types.ProtoCodec{}, nil
+	case "avro":
+		if s.RegistryURL == "" {
+			return nil, fmt.Errorf("avro serialization needs a schema registry URL")
+		}
+		return avrocodec.New(s.RegistryURL, topic)
+	default:
+		return nil, fmt.Errorf("unknown serialization %q, want json, protobuf or avro", s.Format)
+	}
+}
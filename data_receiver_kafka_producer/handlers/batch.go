@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/erastusk/gpscords/data_receiver_kafka_producer/kafka"
+	"github.com/erastusk/gpscords/stats"
+	"github.com/erastusk/gpscords/types"
+)
+
+// BatchSize, when above zero, makes each connection collect up to this many
+// readings per OBU and produce them as a single record holding a JSON array,
+// marked with a batch header. A batch is also produced once its first
+// reading has waited BatchWindow, and when the connection ends. Batching
+// needs the JSON codec.
+var BatchSize int
+
+// BatchWindow is the longest a reading waits for its batch to fill.
+var BatchWindow = 100 * time.Millisecond
+
+// batcher collects one connection's readings into per-OBU batches, so a
+// batch shares one record key and the OBU's readings stay in order.
+type batcher struct {
+	k *kafka.KafkaProducer
+
+	mu      sync.Mutex
+	pending map[int]*batch
+}
+
+type batch struct {
+	readings []types.SourceCoords
+	timer    *time.Timer
+}
+
+func newBatcher(k *kafka.KafkaProducer) *batcher {
+	return &batcher{k: k, pending: make(map[int]*batch)}
+}
+
+// add queues t, producing its OBU's batch if that fills it.
+func (b *batcher) add(t types.SourceCoords) {
+	b.mu.Lock()
+	p, ok := b.pending[t.OBUID]
+	if !ok {
+		p = &batch{}
+		b.pending[t.OBUID] = p
+		p.timer = time.AfterFunc(BatchWindow, func() { b.flushOBU(t.OBUID, p) })
+	}
+	p.readings = append(p.readings, t)
+	full := len(p.readings) >= BatchSize
+	if full {
+		p.timer.Stop()
+		delete(b.pending, t.OBUID)
+	}
+	b.mu.Unlock()
+	if full {
+		b.produce(t.OBUID, p.readings)
+	}
+}
+
+// flushOBU produces p when its window ends, unless it was produced already.
+func (b *batcher) flushOBU(obuid int, p *batch) {
+	b.mu.Lock()
+	if b.pending[obuid] != p {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, obuid)
+	b.mu.Unlock()
+	b.produce(obuid, p.readings)
+}
+
+// close produces every pending batch.
+func (b *batcher) close() {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = make(map[int]*batch)
+	b.mu.Unlock()
+	for obuid, p := range pending {
+		p.timer.Stop()
+		b.produce(obuid, p.readings)
+	}
+}
+
+func (b *batcher) produce(obuid int, readings []types.SourceCoords) {
+	value, err := json.Marshal(readings)
+	if err != nil {
+		slog.Error("Couldn't encode batch", slog.Int("obuid", obuid), slog.Any("err", err))
+		stats.Errors.Add(1)
+		return
+	}
+	headers := []kafka.Header{
+		{Key: types.HeaderOBUID, Value: []byte(strconv.Itoa(obuid))},
+		{Key: types.HeaderBatch, Value: []byte(strconv.Itoa(len(readings)))},
+	}
+	if err := MiddlewareRead(b.k.Key(obuid), value, headers, b.k); err != nil {
+		slog.Error("Couldn't produce batch", slog.Int("obuid", obuid), slog.Int("readings", len(readings)), slog.Any("err", err))
+	}
+}
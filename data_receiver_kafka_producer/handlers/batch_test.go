@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	confluent "github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"github.com/erastusk/gpscords/data_receiver_kafka_producer/kafka"
+	"github.com/erastusk/gpscords/types"
+)
+
+func TestBatcherProducesOneRecordPerBatch(t *testing.T) {
+	mc, err := confluent.NewMockCluster(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	values := make(chan []byte, 10)
+	k, err := kafka.NewKafkaProducer(kafka.Config{
+		Brokers:    mc.BootstrapServers(),
+		Topic:      "gps-test",
+		OnDelivery: func(m *kafka.Message, _ error) { values <- m.Value },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(n int, w time.Duration) { BatchSize, BatchWindow = n, w }(BatchSize, BatchWindow)
+	BatchSize, BatchWindow = 3, time.Minute
+
+	b := newBatcher(k)
+	// OBU 1 fills a batch; OBU 2's lone reading waits for close.
+	for _, r := range []types.SourceCoords{{OBUID: 1, Lat: 1}, {OBUID: 2, Lat: 9}, {OBUID: 1, Lat: 2}, {OBUID: 1, Lat: 3}} {
+		b.add(r)
+	}
+	b.close()
+	k.Close(10 * time.Second)
+	close(values)
+
+	got := map[int][]float64{}
+	records := 0
+	for v := range values {
+		records++
+		var batch []types.SourceCoords
+		if err := json.Unmarshal(v, &batch); err != nil {
+			t.Fatalf("record %s isn't a batch: %v", v, err)
+		}
+		for _, r := range batch {
+			got[batch[0].OBUID] = append(got[batch[0].OBUID], r.Lat)
+		}
+	}
+	if records != 2 {
+		t.Errorf("produced %d records, want 2", records)
+	}
+	if len(got[1]) != 3 || got[1][0] != 1 || got[1][2] != 3 || len(got[2]) != 1 {
+		t.Errorf("got batches %v, want OBU 1's three readings in order and OBU 2's one", got)
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/erastusk/gpscords/logging"
+	"github.com/erastusk/gpscords/stats"
+)
+
+func TestCloseLogging(t *testing.T) {
+	tests := []struct {
+		name      string
+		close     func(c *websocket.Conn)
+		level     string
+		msg       string
+		countsErr bool
+	}{
+		{"normal closure", sendClose(websocket.CloseNormalClosure), "INFO", "Connection closed", false},
+		{"going away", sendClose(websocket.CloseGoingAway), "INFO", "Connection closed", false},
+		{"policy violation", sendClose(websocket.ClosePolicyViolation), "ERROR", "Connection closed unexpectedly", true},
+		{"dropped without a close frame", func(c *websocket.Conn) { c.UnderlyingConn().Close() }, "ERROR", "Connection closed unexpectedly", true},
+	}
+	defer slog.SetDefault(slog.Default())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			slog.SetDefault(logging.New(&buf, "test", "info", "json"))
+			done := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer close(done)
+				c, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ReadMessageLoop(c, nil)
+			}))
+			defer srv.Close()
+			c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			errs := stats.Errors.Load()
+			tt.close(c)
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("ReadMessageLoop didn't return")
+			}
+
+			var entry struct {
+				Level string `json:"level"`
+				Msg   string `json:"msg"`
+			}
+			if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &entry); err != nil {
+				t.Fatalf("%v in log %q", err, buf.Bytes())
+			}
+			if entry.Level != tt.level || entry.Msg != tt.msg {
+				t.Errorf("logged %s %q, want %s %q", entry.Level, entry.Msg, tt.level, tt.msg)
+			}
+			if counted := stats.Errors.Load() > errs; counted != tt.countsErr {
+				t.Errorf("counted as an error %v, want %v", counted, tt.countsErr)
+			}
+		})
+	}
+}
+
+// sendClose returns a func that closes a connection with a close frame
+// carrying code.
+func sendClose(code int) func(c *websocket.Conn) {
+	return func(c *websocket.Conn) {
+		c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(time.Second))
+	}
+}
+
+func TestStalledPeerTimesOut(t *testing.T) {
+	defer func(d time.Duration) { PongWait = d }(PongWait)
+	PongWait = 200 * time.Millisecond
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ReadMessageLoop(c, nil)
+	}))
+	defer srv.Close()
+	// The client never reads, so it never answers a ping.
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	start := time.Now()
+	select {
+	case <-done:
+		if took := time.Since(start); took < PongWait/2 {
+			t.Errorf("handler returned after %v, before the %v pong wait", took, PongWait)
+		}
+	case <-time.After(5 * PongWait):
+		t.Fatalf("handler still running %v after a %v pong wait", 5*PongWait, PongWait)
+	}
+}
+
+func TestOversizedFrameClosesConnection(t *testing.T) {
+	defer func(n int64) { ReadLimit = n }(ReadLimit)
+	ReadLimit = 64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ReadMessageLoop(c, nil)
+	}))
+	defer srv.Close()
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	big := `{"obuid":1,"lat":1,"lon":2,"fw_version":"` + strings.Repeat("x", 100) + `"}`
+	if err := c.WriteMessage(websocket.TextMessage, []byte(big)); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = c.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("client saw %v, want a message-too-big close", err)
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// conns tracks the open WebSocket connections so shutdown can close them
+// and wait for their read loops to finish producing.
+var conns = struct {
+	sync.Mutex
+	open    map[*websocket.Conn]bool
+	closing bool
+	wg      sync.WaitGroup
+}{open: map[*websocket.Conn]bool{}}
+
+// track registers c. It reports false once shutdown has begun, in which
+// case c must not be served.
+func track(c *websocket.Conn) bool {
+	conns.Lock()
+	defer conns.Unlock()
+	if conns.closing {
+		return false
+	}
+	conns.open[c] = true
+	conns.wg.Add(1)
+	return true
+}
+
+func untrack(c *websocket.Conn) {
+	conns.Lock()
+	delete(conns.open, c)
+	conns.Unlock()
+	conns.wg.Done()
+}
+
+// CloseConnections asks every client to go away and waits until their read
+// loops have returned, so nothing is produced afterwards. Connections still
+// open when ctx is done are closed without waiting further.
+func CloseConnections(ctx context.Context) {
+	conns.Lock()
+	conns.closing = true
+	open := make([]*websocket.Conn, 0, len(conns.open))
+	for c := range conns.open {
+		open = append(open, c)
+	}
+	conns.Unlock()
+
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for _, c := range open {
+		c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	}
+	done := make(chan struct{})
+	go func() {
+		conns.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	slog.Warn("Closing connections that didn't go away in time")
+	conns.Lock()
+	for c := range conns.open {
+		c.Close()
+	}
+	conns.Unlock()
+	<-done
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+// UseNumber makes the receiver parse numbers from their literal text, so an
+// OBUID that doesn't fit an int is rejected rather than mangled.
+var UseNumber bool
+
+// AcceptE7 lets older OBU firmware send coordinates as integers scaled by
+// 1e7 in lat_e7/lon_e7. Such readings are recognised by those fields being
+// present and are converted to degrees.
+var AcceptE7 bool
+
+// Strict rejects JSON readings with fields the receiver doesn't know, or
+// without an obuid, lat or lon, instead of treating missing fields as zero.
+// Legacy lat_e7/lon_e7 readings stand in for lat/lon when AcceptE7 is set.
+var Strict bool
+
+// HeartbeatOBUID is the sentinel OBUID of heartbeat readings, which are
+// checked with ValidateHeartbeat rather than Validate. Zero, the default,
+// disables heartbeats, so every reading needs a real OBUID.
+var HeartbeatOBUID int
+
+// e7Scale is the fixed-point scale of legacy integer coordinates.
+const e7Scale = 1e7
+
+type e7Coords struct {
+	LatE7 *int64 `json:"lat_e7"`
+	LonE7 *int64 `json:"lon_e7"`
+}
+
+type numberCoords struct {
+	OBUID     json.Number `json:"obuid"`
+	Lat       json.Number `json:"lat"`
+	Lon       json.Number `json:"lon"`
+	Timestamp time.Time   `json:"timestamp"`
+	FwVersion string      `json:"fw_version"`
+	Seq       uint64      `json:"seq"`
+}
+
+// decodeReadings decodes a WebSocket payload holding either one reading or,
+// in a text frame, a JSON array of readings. Each array element is decoded
+// and validated like a payload of its own; elements that fail are reported
+// in errs without affecting the rest.
+func decodeReadings(messageType int, data []byte) (readings []types.SourceCoords, errs []error) {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	if messageType != websocket.TextMessage || len(trimmed) == 0 || trimmed[0] != '[' {
+		t, err := decodeReading(messageType, data)
+		if err != nil {
+			return nil, []error{err}
+		}
+		return []types.SourceCoords{t}, nil
+	}
+	var elems []json.RawMessage
+	if err := json.Unmarshal(data, &elems); err != nil {
+		return nil, []error{err}
+	}
+	for i, e := range elems {
+		t, err := decodeReading(messageType, e)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reading %d: %w", i, err))
+			continue
+		}
+		readings = append(readings, t)
+	}
+	return readings, errs
+}
+
+// decodeReading decodes and validates a single WebSocket payload. Binary
+// frames carry a protobuf encoded reading (see types/sourcecoords.proto),
+// text frames JSON.
+func decodeReading(messageType int, data []byte) (types.SourceCoords, error) {
+	t, err := decodeFrame(messageType, data)
+	if err != nil {
+		return t, err
+	}
+	if HeartbeatOBUID != 0 && t.OBUID == HeartbeatOBUID {
+		return t, t.ValidateHeartbeat()
+	}
+	return t, t.Validate()
+}
+
+func decodeFrame(messageType int, data []byte) (types.SourceCoords, error) {
+	if messageType == websocket.BinaryMessage {
+		var t types.SourceCoords
+		err := t.UnmarshalProto(data)
+		return t, err
+	}
+	if Strict {
+		if err := checkFields(data); err != nil {
+			return types.SourceCoords{}, err
+		}
+	}
+	t, err := decodeCoords(data)
+	if err != nil || !AcceptE7 {
+		return t, err
+	}
+	var e7 e7Coords
+	if err := json.Unmarshal(data, &e7); err != nil {
+		return t, err
+	}
+	switch {
+	case e7.LatE7 == nil && e7.LonE7 == nil:
+	case e7.LatE7 == nil || e7.LonE7 == nil:
+		return t, fmt.Errorf("legacy reading needs both lat_e7 and lon_e7")
+	default:
+		t.Lat = float64(*e7.LatE7) / e7Scale
+		t.Lon = float64(*e7.LonE7) / e7Scale
+	}
+	return t, nil
+}
+
+// knownFields lists every field a JSON reading may carry, recording which
+// were present. A JSON null counts as absent.
+type knownFields struct {
+	OBUID     json.RawMessage `json:"obuid"`
+	Lat       json.RawMessage `json:"lat"`
+	Lon       json.RawMessage `json:"lon"`
+	Timestamp json.RawMessage `json:"timestamp"`
+	FwVersion json.RawMessage `json:"fw_version"`
+	Seq       json.RawMessage `json:"seq"`
+	LatE7     json.RawMessage `json:"lat_e7"`
+	LonE7     json.RawMessage `json:"lon_e7"`
+}
+
+// checkFields enforces Strict on a JSON reading.
+func checkFields(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var f knownFields
+	if err := dec.Decode(&f); err != nil {
+		return err
+	}
+	present := func(v json.RawMessage) bool { return len(v) > 0 && string(v) != "null" }
+	e7 := present(f.LatE7) || present(f.LonE7)
+	switch {
+	case e7 && !AcceptE7:
+		return fmt.Errorf("legacy lat_e7/lon_e7 coordinates aren't accepted")
+	case !present(f.OBUID):
+		return fmt.Errorf("missing obuid")
+	case e7:
+		return nil
+	case !present(f.Lat):
+		return fmt.Errorf("missing lat")
+	case !present(f.Lon):
+		return fmt.Errorf("missing lon")
+	}
+	return nil
+}
+
+func decodeCoords(data []byte) (types.SourceCoords, error) {
+	var t types.SourceCoords
+	if !UseNumber {
+		err := json.Unmarshal(data, &t)
+		return t, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var n numberCoords
+	if err := dec.Decode(&n); err != nil {
+		return t, err
+	}
+	if n.OBUID != "" {
+		id, err := strconv.ParseInt(n.OBUID.String(), 10, strconv.IntSize)
+		if err != nil {
+			return t, fmt.Errorf("obuid %q: %w", n.OBUID, err)
+		}
+		t.OBUID = int(id)
+	}
+	var err error
+	if t.Lat, err = parseCoord("lat", n.Lat); err != nil {
+		return t, err
+	}
+	if t.Lon, err = parseCoord("lon", n.Lon); err != nil {
+		return t, err
+	}
+	t.Timestamp = n.Timestamp
+	t.FwVersion = n.FwVersion
+	t.Seq = n.Seq
+	return t, nil
+}
+
+func parseCoord(name string, n json.Number) (float64, error) {
+	if n == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(n.String(), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s %q: %w", name, n, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+func TestDecodeReadingHeartbeats(t *testing.T) {
+	tests := []struct {
+		name     string
+		sentinel int
+		msgType  int
+		data     string
+		wantErr  bool
+	}{
+		{"reading", 0, websocket.TextMessage, `{"obuid":7,"lat":1,"lon":2}`, false},
+		{"zero obuid without heartbeats", 0, websocket.TextMessage, `{"obuid":0,"lat":0,"lon":0}`, true},
+		{"empty binary frame", 0, websocket.BinaryMessage, ``, true},
+		{"empty binary frame with heartbeats", -1, websocket.BinaryMessage, ``, true},
+		{"sentinel without heartbeats", 0, websocket.TextMessage, `{"obuid":-1}`, true},
+		{"heartbeat", -1, websocket.TextMessage, `{"obuid":-1,"timestamp":"2020-01-01T00:00:00Z"}`, false},
+		{"heartbeat with position", -1, websocket.TextMessage, `{"obuid":-1,"lat":45,"lon":-120}`, false},
+		{"heartbeat out of range", -1, websocket.TextMessage, `{"obuid":-1,"lat":95,"lon":0}`, true},
+		{"heartbeat in the future", -1, websocket.TextMessage, `{"obuid":-1,"timestamp":"2099-01-01T00:00:00Z"}`, true},
+		{"zero obuid with heartbeats", -1, websocket.TextMessage, `{"obuid":0,"lat":95,"lon":0,"timestamp":"2099-01-01T00:00:00Z"}`, true},
+	}
+	defer func(id int) { HeartbeatOBUID = id }(HeartbeatOBUID)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			HeartbeatOBUID = tt.sentinel
+			_, err := decodeReading(tt.msgType, []byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodeReading(%q) error = %v, want error %v", tt.data, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeReadingsValidatesElements(t *testing.T) {
+	defer func(id int) { HeartbeatOBUID = id }(HeartbeatOBUID)
+	HeartbeatOBUID = -1
+	data := `[{"obuid":1,"lat":1,"lon":1},{"obuid":0,"lat":0,"lon":0},{"obuid":-1,"lat":95,"lon":0},{"obuid":-1}]`
+	readings, errs := decodeReadings(websocket.TextMessage, []byte(data))
+	if len(readings) != 2 || len(errs) != 2 {
+		t.Fatalf("got %d readings and %d errors, want 2 and 2: %v", len(readings), len(errs), errs)
+	}
+	if readings[0].OBUID != 1 || readings[1].OBUID != -1 {
+		t.Errorf("got OBUIDs %d and %d, want 1 and -1", readings[0].OBUID, readings[1].OBUID)
+	}
+}
+
+func TestDecodeReadingUseNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    types.SourceCoords
+		wantErr bool
+	}{
+		{"largest obuid", `{"obuid":9223372036854775807,"lat":1,"lon":2}`, types.SourceCoords{OBUID: math.MaxInt, Lat: 1, Lon: 2}, false},
+		{"obuid overflows", `{"obuid":9223372036854775808,"lat":1,"lon":2}`, types.SourceCoords{}, true},
+		{"fractional obuid", `{"obuid":1.5,"lat":1,"lon":2}`, types.SourceCoords{}, true},
+		{"exponent obuid", `{"obuid":1e3,"lat":1,"lon":2}`, types.SourceCoords{}, true},
+		{"lat 90", `{"obuid":1,"lat":90,"lon":-180}`, types.SourceCoords{OBUID: 1, Lat: 90, Lon: -180}, false},
+		{"lat just past 90", `{"obuid":1,"lat":90.0000001,"lon":0}`, types.SourceCoords{}, true},
+		{"full precision", `{"obuid":1,"lat":48.858370123456789,"lon":2.294481}`, types.SourceCoords{OBUID: 1, Lat: 48.858370123456789, Lon: 2.294481}, false},
+		{"lat overflows float64", `{"obuid":1,"lat":1e400,"lon":0}`, types.SourceCoords{}, true},
+	}
+	defer func(v bool) { UseNumber = v }(UseNumber)
+	UseNumber = true
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeReading(websocket.TextMessage, []byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeReading(%s) error = %v, want error %v", tt.data, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("decodeReading(%s) = %+v, want %+v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeReadingLegacyE7(t *testing.T) {
+	tests := []struct {
+		name     string
+		acceptE7 bool
+		strict   bool
+		data     string
+		want     types.SourceCoords
+		wantErr  bool
+	}{
+		{"scaled", true, false, `{"obuid":1,"lat_e7":488583701,"lon_e7":22944813}`, types.SourceCoords{OBUID: 1, Lat: 48.8583701, Lon: 2.2944813}, false},
+		{"negative", true, false, `{"obuid":1,"lat_e7":-338688000,"lon_e7":-1512093000}`, types.SourceCoords{OBUID: 1, Lat: -33.8688, Lon: -151.2093}, false},
+		{"extremes", true, false, `{"obuid":1,"lat_e7":900000000,"lon_e7":-1800000000}`, types.SourceCoords{OBUID: 1, Lat: 90, Lon: -180}, false},
+		{"out of range", true, false, `{"obuid":1,"lat_e7":900000001,"lon_e7":0}`, types.SourceCoords{}, true},
+		{"missing lon_e7", true, false, `{"obuid":1,"lat_e7":488583701}`, types.SourceCoords{}, true},
+		{"degrees still accepted", true, false, `{"obuid":1,"lat":48.85,"lon":2.29}`, types.SourceCoords{OBUID: 1, Lat: 48.85, Lon: 2.29}, false},
+		{"strict", true, true, `{"obuid":1,"lat_e7":488583701,"lon_e7":22944813}`, types.SourceCoords{OBUID: 1, Lat: 48.8583701, Lon: 2.2944813}, false},
+		{"strict without AcceptE7", false, true, `{"obuid":1,"lat_e7":488583701,"lon_e7":22944813}`, types.SourceCoords{}, true},
+	}
+	defer func(e7, strict bool) { AcceptE7, Strict = e7, strict }(AcceptE7, Strict)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			AcceptE7, Strict = tt.acceptE7, tt.strict
+			got, err := decodeReading(websocket.TextMessage, []byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeReading(%s) error = %v, want error %v", tt.data, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.OBUID != tt.want.OBUID || math.Abs(got.Lat-tt.want.Lat) > 1e-9 || math.Abs(got.Lon-tt.want.Lon) > 1e-9 {
+				t.Errorf("decodeReading(%s) = %+v, want %+v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeReadingFrameTypes(t *testing.T) {
+	want := types.SourceCoords{OBUID: 42, Lat: 48.8583701, Lon: 2.2944813, Timestamp: time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC), FwVersion: "2.1.0", Seq: 9}
+	text, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		msgType int
+		data    []byte
+		wantErr bool
+	}{
+		{"json text frame", websocket.TextMessage, text, false},
+		{"protobuf binary frame", websocket.BinaryMessage, want.MarshalProto(), false},
+		{"json in a binary frame", websocket.BinaryMessage, text, true},
+		{"protobuf in a text frame", websocket.TextMessage, want.MarshalProto(), true},
+		{"truncated protobuf", websocket.BinaryMessage, want.MarshalProto()[:5], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeReading(tt.msgType, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeReading error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !got.Timestamp.Equal(want.Timestamp) {
+				t.Errorf("timestamp = %v, want %v", got.Timestamp, want.Timestamp)
+			}
+			got.Timestamp = want.Timestamp
+			if !tt.wantErr && got != want {
+				t.Errorf("decodeReading = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeReadingRejectsNonFinite(t *testing.T) {
+	// JSON has no NaN or infinity, but protobuf doubles do.
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		for _, r := range []types.SourceCoords{{OBUID: 1, Lat: v}, {OBUID: 1, Lon: v}} {
+			if _, err := decodeReading(websocket.BinaryMessage, r.MarshalProto()); err == nil {
+				t.Errorf("reading with lat %v, lon %v accepted", r.Lat, r.Lon)
+			}
+		}
+	}
+}
+
+func TestDecodeReadingStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		strict  bool
+		data    string
+		wantErr bool
+	}{
+		{"valid", true, `{"obuid":1,"lat":1,"lon":2}`, false},
+		{"valid with optionals", true, `{"obuid":1,"lat":1,"lon":2,"timestamp":"2020-01-01T00:00:00Z","fw_version":"2.1.0","seq":3}`, false},
+		{"extra field", true, `{"obuid":1,"lat":1,"lon":2,"alt":300}`, true},
+		{"missing lat", true, `{"obuid":1,"lon":2}`, true},
+		{"missing lon", true, `{"obuid":1,"lat":1}`, true},
+		{"null lat", true, `{"obuid":1,"lat":null,"lon":2}`, true},
+		{"missing obuid", true, `{"lat":1,"lon":2}`, true},
+		{"extra field without strict", false, `{"obuid":1,"lat":1,"lon":2,"alt":300}`, false},
+		{"missing lat without strict", false, `{"obuid":1,"lon":2}`, false},
+	}
+	defer func(v bool) { Strict = v }(Strict)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Strict = tt.strict
+			_, err := decodeReading(websocket.TextMessage, []byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodeReading(%s) error = %v, want error %v", tt.data, err, tt.wantErr)
+			}
+		})
+	}
+}
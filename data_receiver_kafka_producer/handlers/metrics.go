@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	unexpectedClosures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "websocket_unexpected_closures_total",
+		Help: "WebSocket connections that ended without a normal or going-away close.",
+	})
+	rateLimited = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "readings_rate_limited_total",
+		Help: "Readings dropped because their OBU exceeded the rate limit.",
+	})
+)
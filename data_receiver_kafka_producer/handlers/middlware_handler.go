@@ -1,16 +1,47 @@
 package handlers
 
 import (
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/erastusk/gpscords/data_receiver_kafka_producer/kafka"
 )
 
-func MiddlewareRead(w []byte, t *kafka.KafkaProducer) {
-	start := time.Now()
-	defer func() {
-		log.Println("Writing to Kafka took: ", time.Since(start))
-	}()
-	t.KafkaWrite(w)
+// Handler produces one record.
+type Handler func(key, value []byte, headers []kafka.Header) error
+
+// Middleware wraps a Handler, for example to time, count or filter records.
+// Returning an error without calling next stops the record there.
+type Middleware func(next Handler) Handler
+
+// Middlewares wrap every record the receiver produces, the first outermost.
+// It must be set before serving.
+var Middlewares = []Middleware{Timing}
+
+// Chain wraps h in mws so that they run in order before h.
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// Timing logs how long the rest of the chain took.
+func Timing(next Handler) Handler {
+	return func(key, value []byte, headers []kafka.Header) error {
+		start := time.Now()
+		defer func() {
+			slog.Debug("Wrote to Kafka", slog.Duration("produce_took", time.Since(start)))
+		}()
+		return next(key, value, headers)
+	}
+}
+
+// MiddlewareRead produces w under key through Middlewares and returns any
+// error from queueing it so the caller can report the failure.
+func MiddlewareRead(key, w []byte, headers []kafka.Header, t *kafka.KafkaProducer) error {
+	produce := func(key, value []byte, headers []kafka.Header) error {
+		return t.KafkaWriteKeyed(key, value, headers...)
+	}
+	return Chain(produce, Middlewares...)(key, w, headers)
 }
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/erastusk/gpscords/data_receiver_kafka_producer/kafka"
+)
+
+func TestMiddlewareReadReturnsProduceErrors(t *testing.T) {
+	k, err := kafka.NewKafkaProducer(kafka.Config{Brokers: "127.0.0.1:1", MaxMessageBytes: 16, OnDelivery: func(*kafka.Message, error) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close(0)
+	tests := []struct {
+		name  string
+		value string
+		want  error
+	}{
+		{"queued", `{"obuid":1}`, nil},
+		{"rejected", `{"obuid":1,"lat":48.8583701,"lon":2.2944813}`, kafka.ErrMessageTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := MiddlewareRead(nil, []byte(tt.value), nil, k)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("MiddlewareRead error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	errRejected := errors.New("rejected")
+	// record returns a middleware noting name as it runs, and stopping the
+	// record there if reject is set.
+	var ran []string
+	record := func(name string, reject bool) Middleware {
+		return func(next Handler) Handler {
+			return func(key, value []byte, headers []kafka.Header) error {
+				ran = append(ran, name)
+				if reject {
+					return errRejected
+				}
+				return next(key, value, headers)
+			}
+		}
+	}
+	produce := func([]byte, []byte, []kafka.Header) error {
+		ran = append(ran, "produce")
+		return nil
+	}
+	tests := []struct {
+		name    string
+		mws     []Middleware
+		want    []string
+		wantErr error
+	}{
+		{"no middleware", nil, []string{"produce"}, nil},
+		{"in order", []Middleware{record("a", false), record("b", false), record("c", false)}, []string{"a", "b", "c", "produce"}, nil},
+		{"short circuit", []Middleware{record("a", false), record("b", true), record("c", false)}, []string{"a", "b"}, errRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran = nil
+			err := Chain(produce, tt.mws...)(nil, nil, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(ran, tt.want) {
+				t.Errorf("ran %v, want %v", ran, tt.want)
+			}
+		})
+	}
+}
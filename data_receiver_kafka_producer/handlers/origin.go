@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// AllowOrigins restricts WebSocket upgrades to requests whose Origin header
+// is one of origins, compared case-insensitively. "*" allows any origin.
+// Requests without an Origin header, which come from non-browser clients
+// such as the producer, are always allowed. With no origins the upgrader
+// keeps its default, which only checks browsers against the request host.
+// It must be called before serving.
+func AllowOrigins(origins []string) {
+	if len(origins) == 0 {
+		return
+	}
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[strings.ToLower(strings.TrimSpace(o))] = true
+	}
+	upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || allowed["*"] || allowed[strings.ToLower(origin)]
+	}
+}
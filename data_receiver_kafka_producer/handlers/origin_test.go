@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestAllowOrigins(t *testing.T) {
+	defer func(check func(*http.Request) bool) { upgrader.CheckOrigin = check }(upgrader.CheckOrigin)
+	AllowOrigins([]string{"https://dashboard.example.com", " HTTPS://ops.example.com "})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := upgrader.Upgrade(w, r, nil); err == nil {
+			c.Close()
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://dashboard.example.com", true},
+		{"https://OPS.example.com", true},
+		{"", true},
+		{"https://evil.example.com", false},
+		{"http://dashboard.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			header := http.Header{}
+			if tt.origin != "" {
+				header.Set("Origin", tt.origin)
+			}
+			c, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), header)
+			if err == nil {
+				c.Close()
+			}
+			if (err == nil) != tt.want {
+				t.Fatalf("upgrade error = %v, want success %v", err, tt.want)
+			}
+			if !tt.want && resp.StatusCode != http.StatusForbidden {
+				t.Errorf("status %d, want %d", resp.StatusCode, http.StatusForbidden)
+			}
+		})
+	}
+}
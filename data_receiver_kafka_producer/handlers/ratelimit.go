@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"container/list"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// RateLimit, when set, limits how fast each OBU's readings are produced.
+var RateLimit *RateLimiter
+
+type bucket struct {
+	obuid  int
+	tokens float64
+	at     time.Time
+}
+
+// RateLimiter keeps a token bucket per OBU, so one flooding vehicle is
+// dropped without affecting the others. Buckets refill at rate tokens per
+// second up to burst. Only the most recently seen maxOBUs vehicles are
+// tracked; a forgotten OBU starts again with a full bucket.
+type RateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	maxOBUs int
+	recent  *list.List
+	buckets map[int]*list.Element
+}
+
+// NewRateLimiter returns a limiter allowing rate readings per second per
+// OBU, with bursts of up to burst readings.
+func NewRateLimiter(rate float64, burst, maxOBUs int) (*RateLimiter, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("rate limit must be positive, got %v", rate)
+	}
+	if burst < 1 {
+		return nil, fmt.Errorf("rate limit burst must be at least 1, got %d", burst)
+	}
+	if maxOBUs <= 0 {
+		return nil, fmt.Errorf("rate limit needs room for at least one OBU, got %d", maxOBUs)
+	}
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		maxOBUs: maxOBUs,
+		recent:  list.New(),
+		buckets: make(map[int]*list.Element),
+	}, nil
+}
+
+// Allow takes a token from obuid's bucket and reports whether there was one.
+// Readings that are refused are logged and counted.
+func (r *RateLimiter) Allow(obuid int, now time.Time) bool {
+	if r.take(obuid, now) {
+		return true
+	}
+	slog.Debug("Dropping reading over the rate limit", slog.Int("obuid", obuid))
+	rateLimited.Inc()
+	return false
+}
+
+func (r *RateLimiter) take(obuid int, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	el, seen := r.buckets[obuid]
+	if !seen {
+		el = r.recent.PushFront(&bucket{obuid: obuid, tokens: r.burst, at: now})
+		r.buckets[obuid] = el
+		if r.recent.Len() > r.maxOBUs {
+			oldest := r.recent.Back()
+			r.recent.Remove(oldest)
+			delete(r.buckets, oldest.Value.(*bucket).obuid)
+		}
+	} else {
+		r.recent.MoveToFront(el)
+	}
+	b := el.Value.(*bucket)
+	if dt := now.Sub(b.at).Seconds(); dt > 0 {
+		b.tokens = min(r.burst, b.tokens+dt*r.rate)
+		b.at = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
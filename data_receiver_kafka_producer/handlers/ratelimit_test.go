@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	confluent "github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"github.com/erastusk/gpscords/data_receiver_kafka_producer/kafka"
+	"github.com/erastusk/gpscords/types"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		obuid int
+		at    time.Duration
+		want  bool
+	}{
+		{"burst 1", 1, 0, true},
+		{"burst 2", 1, 0, true},
+		{"burst 3", 1, 0, true},
+		{"over the burst", 1, 0, false},
+		{"other OBU unaffected", 2, 0, true},
+		{"half a token later", 1, 250 * time.Millisecond, false},
+		{"a token later", 1, 500 * time.Millisecond, true},
+		{"spent again", 1, 500 * time.Millisecond, false},
+		{"other OBU seen again", 2, 500 * time.Millisecond, true},
+		{"third OBU evicts the least recent", 3, 500 * time.Millisecond, true},
+		{"evicted OBU starts full", 1, 500 * time.Millisecond, true},
+	}
+	// Two tokens a second, bursts of three, two OBUs tracked.
+	r, err := NewRateLimiter(2, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if got := r.Allow(tt.obuid, t0.Add(tt.at)); got != tt.want {
+			t.Errorf("%s: Allow(%d) = %v, want %v", tt.name, tt.obuid, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitedReadingsArentProduced(t *testing.T) {
+	mc, err := confluent.NewMockCluster(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	var delivered atomic.Int64
+	k, err := kafka.NewKafkaProducer(kafka.Config{
+		Brokers: mc.BootstrapServers(),
+		Topic:   "gps-test",
+		OnDelivery: func(_ *kafka.Message, err error) {
+			if err == nil {
+				delivered.Add(1)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(r *RateLimiter) { RateLimit = r }(RateLimit)
+	// A slow refill, so the burst is all that gets through.
+	if RateLimit, err = NewRateLimiter(0.001, 5, 10); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		forward(types.SourceCoords{OBUID: 7, Lat: 1, Lon: 1}, k, nil)
+	}
+	k.Close(10 * time.Second)
+	if n := delivered.Load(); n != 5 {
+		t.Errorf("%d of 50 readings produced, want the burst of 5", n)
+	}
+}
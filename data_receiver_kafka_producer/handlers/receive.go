@@ -1,51 +1,163 @@
 package handlers
 
 import (
-	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/websocket"
 
 	"github.com/erastusk/gpscords/data_receiver_kafka_producer/kafka"
+	"github.com/erastusk/gpscords/stats"
 	"github.com/erastusk/gpscords/types"
 )
 
+// Codec serializes readings for Kafka.
+var Codec types.Codec = types.JSONCodec{}
+
+// PongWait is how long a client may go without answering a ping, or sending
+// anything else, before its connection is closed. Pings are sent at 9/10 of
+// it. Zero disables keepalive, leaving idle connections open indefinitely.
+var PongWait = 60 * time.Second
+
+// ReadLimit is the largest message, in bytes, a client may send. A larger
+// one closes the connection with a message-too-big status. Zero means no
+// limit.
+var ReadLimit int64 = 64 << 10
+
 var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1028,
-	WriteBufferSize: 1028,
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
 }
 
-func ReceiveWs(w http.ResponseWriter, r *http.Request) {
-	c, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println(err)
+// SetBufferSizes sets the I/O buffer sizes, in bytes, of new connections.
+// They don't limit message size. It must be called before serving.
+func SetBufferSizes(read, write int) {
+	upgrader.ReadBufferSize = read
+	upgrader.WriteBufferSize = write
+}
+
+// ReceiveWs returns the WebSocket handler. Every connection produces through
+// the shared producer k.
+func ReceiveWs(k *kafka.KafkaProducer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			slog.Error("WebSocket upgrade failed", slog.String("remote", r.RemoteAddr), slog.Any("err", err))
+			stats.Errors.Add(1)
+			return
+		}
+		if !track(c) {
+			c.Close()
+			return
+		}
+		defer untrack(c)
+		ReadMessageLoop(c, k)
 	}
-	ReadMessageLoop(c)
 }
 
-func ReadMessageLoop(c *websocket.Conn) {
+func ReadMessageLoop(c *websocket.Conn, k *kafka.KafkaProducer) {
 	defer c.Close()
-	k, err := kafka.NewKafkaProducer()
-	if err != nil {
-		fmt.Println(err)
+	if ReadLimit > 0 {
+		c.SetReadLimit(ReadLimit)
+	}
+	if PongWait > 0 {
+		stop := keepalive(c, PongWait)
+		defer stop()
+	}
+	var b *batcher
+	if BatchSize > 0 {
+		b = newBatcher(k)
+		defer b.close()
 	}
-	var recv types.SourceCoords
 	for {
-		err := c.ReadJSON(&recv)
+		mt, data, err := c.ReadMessage()
 		if err != nil {
-			websocket.IsUnexpectedCloseError(err,
-				websocket.CloseAbnormalClosure,
-				websocket.CloseGoingAway,
-			)
-			log.Println("Unexpected closure")
+			logClose(c, err)
 			break
+		}
+		if PongWait > 0 {
+			c.SetReadDeadline(time.Now().Add(PongWait))
+		}
+		readings, errs := decodeReadings(mt, data)
+		for _, err := range errs {
+			slog.Warn("Rejecting malformed reading", slog.Any("err", err))
+			stats.Errors.Add(1)
+		}
+		for _, recv := range readings {
+			forward(recv, k, b)
+		}
+	}
+}
+
+// forward screens one decoded reading and produces it, through b when
+// batching.
+func forward(recv types.SourceCoords, k *kafka.KafkaProducer, b *batcher) {
+	if RateLimit != nil && !RateLimit.Allow(recv.OBUID, time.Now()) {
+		return
+	}
+	if SpeedCheck != nil && !SpeedCheck.Allow(recv, time.Now()) {
+		return
+	}
+	slog.Info("Received reading", slog.Int("obuid", recv.OBUID),
+		slog.Float64("lat", recv.Lat), slog.Float64("lon", recv.Lon), slog.Time("timestamp", recv.Timestamp))
+	if b != nil {
+		b.add(recv)
+		return
+	}
+	resp, err := Codec.Encode(recv)
+	if err != nil {
+		slog.Error("Couldn't encode reading", slog.Int("obuid", recv.OBUID), slog.Any("err", err))
+		stats.Errors.Add(1)
+		return
+	}
+	headers := []kafka.Header{{Key: types.HeaderOBUID, Value: []byte(strconv.Itoa(recv.OBUID))}}
+	if err := MiddlewareRead(k.Key(recv.OBUID), resp, headers, k); err != nil {
+		slog.Error("Couldn't produce reading", slog.Int("obuid", recv.OBUID), slog.Any("err", err))
+	}
+}
 
+// keepalive sets a read deadline of wait on c, extends it whenever a pong or
+// message arrives, and pings c often enough that a live client always
+// answers in time. A half-open connection then fails its next read instead
+// of blocking forever. The returned func stops the pings.
+func keepalive(c *websocket.Conn, wait time.Duration) (stop func()) {
+	extend := func() { c.SetReadDeadline(time.Now().Add(wait)) }
+	extend()
+	c.SetPongHandler(func(string) error {
+		extend()
+		return nil
+	})
+	done := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(wait * 9 / 10)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+			}
+			// WriteControl is safe alongside the close written on shutdown.
+			if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(wait)); err != nil {
+				return
+			}
 		}
-		log.Printf("kafka receiver: %v", recv)
-		resp, err := json.Marshal(recv)
-		log.Println(string(resp))
-		MiddlewareRead(resp, k)
+	}()
+	return func() { close(done) }
+}
+
+// logClose reports why the connection ended. Clients closing normally or
+// going away are routine; anything else, including connections dropped
+// without a close frame, is counted as an error.
+func logClose(c *websocket.Conn, err error) {
+	remote := slog.String("remote", c.RemoteAddr().String())
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		slog.Info("Connection closed", remote)
+		return
 	}
+	slog.Error("Connection closed unexpectedly", remote, slog.Any("err", err))
+	unexpectedClosures.Inc()
+	stats.Errors.Add(1)
 }
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	confluent "github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/gorilla/websocket"
+
+	"github.com/erastusk/gpscords/data_receiver_kafka_producer/kafka"
+	"github.com/erastusk/gpscords/types"
+)
+
+func TestConnectionsShareTheProducer(t *testing.T) {
+	mc, err := confluent.NewMockCluster(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	// Only records produced through k are reported here; a producer made
+	// per connection would report elsewhere.
+	var delivered atomic.Int64
+	k, err := kafka.NewKafkaProducer(kafka.Config{
+		Brokers: mc.BootstrapServers(),
+		Topic:   "gps-test",
+		OnDelivery: func(_ *kafka.Message, err error) {
+			if err == nil {
+				delivered.Add(1)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close(time.Second)
+	srv := httptest.NewServer(ReceiveWs(k))
+	defer srv.Close()
+
+	const connections = 10
+	for i := 1; i <= connections; i++ {
+		c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.WriteJSON(map[string]any{"obuid": i, "lat": 1, "lon": 1}); err != nil {
+			t.Fatal(err)
+		}
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		c.Close()
+	}
+	waitDelivered := func(want int64) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for delivered.Load() < want && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := delivered.Load(); n != want {
+			t.Fatalf("%d of %d records delivered through the shared producer", n, want)
+		}
+	}
+	waitDelivered(connections)
+	// Closing every connection must leave the producer usable.
+	if err := k.KafkaWrite([]byte(`{"obuid":11}`)); err != nil {
+		t.Fatalf("producer unusable after connections closed: %v", err)
+	}
+	waitDelivered(connections + 1)
+}
+
+func TestProducedHeaders(t *testing.T) {
+	mc, err := confluent.NewMockCluster(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	delivered := make(chan *kafka.Message, 1)
+	k, err := kafka.NewKafkaProducer(kafka.Config{
+		Brokers:    mc.BootstrapServers(),
+		Topic:      "gps-test",
+		OnDelivery: func(m *kafka.Message, _ error) { delivered <- m },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close(time.Second)
+	srv := httptest.NewServer(ReceiveWs(k))
+	defer srv.Close()
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WriteJSON(map[string]any{"obuid": 42, "lat": 1, "lon": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var m *kafka.Message
+	select {
+	case m = <-delivered:
+	case <-time.After(10 * time.Second):
+		t.Fatal("record not delivered")
+	}
+	if m.TopicPartition.Error != nil {
+		t.Fatal(m.TopicPartition.Error)
+	}
+	// Delivery reports don't carry headers, so read the record back.
+	consumer, err := confluent.NewConsumer(&confluent.ConfigMap{"bootstrap.servers": mc.BootstrapServers(), "group.id": "headers"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+	if err := consumer.Assign([]confluent.TopicPartition{m.TopicPartition}); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = consumer.ReadMessage(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	headers := map[string]string{}
+	for _, h := range m.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	if got := headers[types.HeaderOBUID]; got != "42" {
+		t.Errorf("%s header %q, want 42", types.HeaderOBUID, got)
+	}
+	if got := headers[types.HeaderProducerVersion]; got == "" {
+		t.Errorf("no %s header in %v", types.HeaderProducerVersion, m.Headers)
+	}
+}
+
+func TestSingleAndArrayPayloads(t *testing.T) {
+	mc, err := confluent.NewMockCluster(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	values := make(chan string, 10)
+	k, err := kafka.NewKafkaProducer(kafka.Config{
+		Brokers: mc.BootstrapServers(),
+		Topic:   "gps-test",
+		OnDelivery: func(m *kafka.Message, err error) {
+			if err == nil {
+				values <- string(m.Value)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close(time.Second)
+	srv := httptest.NewServer(ReceiveWs(k))
+	defer srv.Close()
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, payload := range []string{
+		`{"obuid":1,"lat":1,"lon":1}`,
+		`[{"obuid":2,"lat":2,"lon":2},{"obuid":3,"lat":0,"lon":0},{"obuid":4,"lat":4,"lon":4}]`,
+	} {
+		if err := c.WriteMessage(websocket.TextMessage, []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := map[int]int{}
+	timeout := time.After(10 * time.Second)
+	for n := 0; n < 4; n++ {
+		select {
+		case v := <-values:
+			var r types.SourceCoords
+			if err := json.Unmarshal([]byte(v), &r); err != nil {
+				t.Fatalf("record %s: %v", v, err)
+			}
+			got[r.OBUID]++
+		case <-timeout:
+			t.Fatalf("%d of 4 readings produced: %v", n, got)
+		}
+	}
+	if want := map[int]int{1: 1, 2: 1, 3: 1, 4: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("records per OBU = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"container/list"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/erastusk/gpscords/geo"
+	"github.com/erastusk/gpscords/types"
+)
+
+// Actions a SpeedChecker takes on a reading that implies an impossible speed.
+const (
+	SpeedActionDrop = "drop"
+	SpeedActionFlag = "flag"
+)
+
+// SpeedCheck, when set, screens every reading before it is produced.
+var SpeedCheck *SpeedChecker
+
+type lastFix struct {
+	obuid    int
+	lat, lon float64
+	at       time.Time
+}
+
+// SpeedChecker remembers the last accepted fix per OBU and rejects readings
+// that would require travelling faster than maxSpeed to reach. Only the most
+// recently seen maxOBUs vehicles are tracked.
+type SpeedChecker struct {
+	mu       sync.Mutex
+	maxSpeed float64
+	action   string
+	maxOBUs  int
+	recent   *list.List
+	fixes    map[int]*list.Element
+}
+
+// NewSpeedChecker returns a checker for maxSpeed in meters per second.
+func NewSpeedChecker(maxSpeed float64, action string, maxOBUs int) (*SpeedChecker, error) {
+	if action != SpeedActionDrop && action != SpeedActionFlag {
+		return nil, fmt.Errorf("unknown speed action %q", action)
+	}
+	if maxOBUs <= 0 {
+		return nil, fmt.Errorf("speed check needs room for at least one OBU, got %d", maxOBUs)
+	}
+	return &SpeedChecker{
+		maxSpeed: maxSpeed,
+		action:   action,
+		maxOBUs:  maxOBUs,
+		recent:   list.New(),
+		fixes:    make(map[int]*list.Element),
+	}, nil
+}
+
+// Check returns the speed implied by t and whether it is plausible. Only
+// plausible readings become the reference for the next one, so a single
+// glitch doesn't poison the OBU's history.
+func (s *SpeedChecker) Check(t types.SourceCoords, now time.Time) (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, seen := s.fixes[t.OBUID]
+	if seen {
+		prev := el.Value.(*lastFix)
+		if dt := now.Sub(prev.at).Seconds(); dt > 0 {
+			speed := geo.Haversine(prev.lat, prev.lon, t.Lat, t.Lon) / dt
+			if speed > s.maxSpeed {
+				return speed, false
+			}
+		}
+		prev.lat, prev.lon, prev.at = t.Lat, t.Lon, now
+		s.recent.MoveToFront(el)
+		return 0, true
+	}
+	s.fixes[t.OBUID] = s.recent.PushFront(&lastFix{obuid: t.OBUID, lat: t.Lat, lon: t.Lon, at: now})
+	if s.recent.Len() > s.maxOBUs {
+		oldest := s.recent.Back()
+		s.recent.Remove(oldest)
+		delete(s.fixes, oldest.Value.(*lastFix).obuid)
+	}
+	return 0, true
+}
+
+// Allow applies the configured action and reports whether t should be
+// produced.
+func (s *SpeedChecker) Allow(t types.SourceCoords, now time.Time) bool {
+	speed, ok := s.Check(t, now)
+	if ok {
+		return true
+	}
+	if s.action == SpeedActionDrop {
+		slog.Warn("Dropping reading with implausible speed", slog.Int("obuid", t.OBUID), slog.Float64("speed_mps", speed))
+		return false
+	}
+	slog.Warn("Suspicious reading with implausible speed", slog.Int("obuid", t.OBUID), slog.Float64("speed_mps", speed))
+	return true
+}
@@ -1,60 +1,370 @@
 package kafka
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"os"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/erastusk/gpscords/config"
+	"github.com/erastusk/gpscords/stats"
+	"github.com/erastusk/gpscords/types"
 )
 
 // ConfigMap documentation
 // https://github.com/confluentinc/librdkafka/blob/master/CONFIGURATION.md
+const (
+	DefaultBrokers = "gpscords_app-kafka-1:9092"
+	DefaultTopic   = "gpscoords"
+)
+
+// Compression codecs accepted by Config.Compression.
+var compressionTypes = map[string]bool{
+	"none":   true,
+	"gzip":   true,
+	"snappy": true,
+	"lz4":    true,
+	"zstd":   true,
+}
+
+// DefaultMaxMessageBytes matches the broker's default message.max.bytes.
+const DefaultMaxMessageBytes = 1000000
+
+// ErrMessageTooLarge is returned for records bigger than
+// Config.MaxMessageBytes. They are rejected locally instead of failing
+// later with a broker error.
+var ErrMessageTooLarge = errors.New("message exceeds size limit")
+
+// Errors wrapped by the producer's failures, for use with errors.Is.
 var (
-	server = "gpscords_app-kafka-1:9092"
-	topic  = "gpscoords"
+	// ErrProducerInit is wrapped by NewKafkaProducer when the client can't
+	// be set up.
+	ErrProducerInit = errors.New("kafka producer init failed")
+	// ErrProduce is wrapped by the KafkaWrite methods when a record can't
+	// be queued for producing.
+	ErrProduce = errors.New("kafka produce failed")
 )
 
+// Config holds the tunables for a KafkaProducer. ConfigFlags binds the
+// connection settings to flags.
+type Config struct {
+	// Brokers is the bootstrap.servers list. Empty means DefaultBrokers.
+	Brokers string
+	// Topic records are produced to. Empty means DefaultTopic.
+	Topic string
+	// Security configures SASL and TLS. The zero value connects in
+	// plaintext.
+	Security config.KafkaSecurity
+	// Compression is the compression.type of produced batches: none, gzip,
+	// snappy, lz4 or zstd. Empty means none.
+	Compression string
+	// QueueFullRetries is how often a write is retried while the local queue
+	// is full, waiting 10ms before the first retry and twice as long before
+	// each further one, up to a second. Zero fails such writes at once.
+	QueueFullRetries int
+	// DisableIdempotence turns off idempotent producing. It is on by default
+	// so broker-side retries can't duplicate or reorder records, at the
+	// cost of waiting for every in-sync replica to acknowledge (acks=all)
+	// and of at most five requests in flight per broker. Turning it off
+	// trades those guarantees for lower produce latency.
+	DisableIdempotence bool
+	// MessageTTL stamps every record with an expires-at header when non-zero.
+	// Zero leaves records without an expiry.
+	MessageTTL time.Duration
+	// KeyFormat controls how OBUIDs are written as record keys. Empty means
+	// KeyDecimal.
+	KeyFormat KeyFormat
+	// ConsistentRouting assigns keyed records to partitions by consistent
+	// hashing instead of librdkafka's partitioner, so adding partitions only
+	// moves a small share of OBUs. See jumpHash.
+	ConsistentRouting bool
+	// MaxMessageBytes is the largest key plus value accepted. Zero means
+	// DefaultMaxMessageBytes.
+	MaxMessageBytes int
+	// Linger is how long records wait to be batched with others before
+	// being sent (linger.ms). Zero keeps the librdkafka default.
+	Linger time.Duration
+	// BatchSize caps the bytes in one batch (batch.size). Zero keeps the
+	// librdkafka default.
+	BatchSize int
+	// MaxQueued bounds the records awaiting delivery
+	// (queue.buffering.max.messages). Writes beyond it fail with
+	// ErrQueueFull instead of growing memory. Zero keeps the librdkafka
+	// default.
+	MaxQueued int
+	// OnDelivery is called from the producer's events goroutine with every
+	// delivery report, err being nil on success. It must not block for
+	// long. Nil means LogDelivery. The produced and error metrics are kept
+	// either way.
+	OnDelivery func(msg *Message, err error)
+}
+
+// ConfigFlags defines the connection flags on fs: -kafka-brokers,
+// -kafka-topic, -kafka-compression and those of config.KafkaSecurityFlags.
+// They fill the returned Config when fs is loaded, so they resolve like any
+// other setting. Each defaults to the KAFKA_* variable earlier releases read,
+// e.g. KAFKA_BROKERS, else to the package default.
+func ConfigFlags(fs *flag.FlagSet) *Config {
+	cfg := &Config{}
+	fs.StringVar(&cfg.Brokers, "kafka-brokers", getenv("KAFKA_BROKERS", DefaultBrokers), "comma-separated Kafka bootstrap servers")
+	fs.StringVar(&cfg.Topic, "kafka-topic", getenv("KAFKA_TOPIC", DefaultTopic), "topic readings are produced to")
+	fs.StringVar(&cfg.Compression, "kafka-compression", getenv("KAFKA_COMPRESSION", "none"), "compression of produced batches: none, gzip, snappy, lz4 or zstd")
+	config.KafkaSecurityFlags(fs, &cfg.Security)
+	return cfg
+}
+
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// Header is a Kafka record header.
+type Header = kafka.Header
+
+// Message is a Kafka record, as passed to Config.OnDelivery.
+type Message = kafka.Message
+
+// LogDelivery logs failed deliveries as errors and successful ones at debug
+// level. It is the default Config.OnDelivery.
+func LogDelivery(msg *Message, err error) {
+	if err != nil {
+		slog.Error("Failed to deliver record", slog.Any("err", err),
+			slog.Int("partition", int(msg.TopicPartition.Partition)))
+		return
+	}
+	slog.Debug("Produced record", slog.String("topic", *msg.TopicPartition.Topic),
+		slog.Int("partition", int(msg.TopicPartition.Partition)), slog.Int64("offset", int64(msg.TopicPartition.Offset)))
+}
+
 type KafkaProducer struct {
 	Producer   *kafka.Producer
 	topic      string
 	chan_event chan kafka.Event
+	ttl        time.Duration
+	keyFormat  KeyFormat
+	router     *router
+	maxBytes   int
+	retries    int
+}
+
+// withDefaults fills the unset fields of cfg that have defaults.
+func (cfg Config) withDefaults() Config {
+	if cfg.Brokers == "" {
+		cfg.Brokers = DefaultBrokers
+	}
+	if cfg.Topic == "" {
+		cfg.Topic = DefaultTopic
+	}
+	if cfg.Compression == "" {
+		cfg.Compression = "none"
+	}
+	if cfg.MaxMessageBytes == 0 {
+		cfg.MaxMessageBytes = DefaultMaxMessageBytes
+	}
+	return cfg
+}
+
+// configMap builds the librdkafka configuration for cfg.
+func configMap(cfg Config) (kafka.ConfigMap, error) {
+	if !compressionTypes[cfg.Compression] {
+		return nil, fmt.Errorf("unknown compression %q, want none, gzip, snappy, lz4 or zstd", cfg.Compression)
+	}
+	cm := kafka.ConfigMap{
+		"bootstrap.servers": cfg.Brokers,
+		"compression.type":  cfg.Compression,
+	}
+	for k, v := range cfg.Security.Properties() {
+		cm[k] = v
+	}
+	if !cfg.DisableIdempotence {
+		cm["enable.idempotence"] = true
+		cm["acks"] = "all"
+		cm["max.in.flight.requests.per.connection"] = 5
+	}
+	if cfg.Linger > 0 {
+		cm["linger.ms"] = int(cfg.Linger.Milliseconds())
+	}
+	if cfg.BatchSize > 0 {
+		cm["batch.size"] = cfg.BatchSize
+	}
+	if cfg.MaxQueued > 0 {
+		cm["queue.buffering.max.messages"] = cfg.MaxQueued
+	}
+	return cm, nil
 }
 
 // To produce asynchronously, you can use a Goroutine to handle message delivery reports and possibly other event types (errors, stats, etc) concurrently:
-func NewKafkaProducer() (*KafkaProducer, error) {
-	p, err := kafka.NewProducer(&kafka.ConfigMap{
-		"bootstrap.servers": server,
-	})
+func NewKafkaProducer(cfg Config) (*KafkaProducer, error) {
+	cfg = cfg.withDefaults()
+	cm, err := configMap(cfg)
+	if err != nil {
+		return nil, err
+	}
+	keyFormat, err := ParseKeyFormat(string(cfg.KeyFormat))
 	if err != nil {
-		fmt.Println("Failed to create Kafka producer", err)
 		return nil, err
 	}
+	p, err := kafka.NewProducer(&cm)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrProducerInit, err)
+	}
+	var r *router
+	if cfg.ConsistentRouting {
+		if r, err = newRouter(p, cfg.Topic); err != nil {
+			p.Close()
+			return nil, fmt.Errorf("%w: %w", ErrProducerInit, err)
+		}
+	}
+	onDelivery := cfg.OnDelivery
+	if onDelivery == nil {
+		onDelivery = LogDelivery
+	}
+	// Always drain the events, whatever onDelivery does, or producing
+	// eventually blocks.
 	go func() {
 		for e := range p.Events() {
 			switch ev := e.(type) {
 			case *kafka.Message:
 				if ev.TopicPartition.Error != nil {
-					fmt.Printf("Failed to deliver message: %v\n", ev.TopicPartition)
+					stats.Errors.Add(1)
+					produceErrors.Inc()
 				} else {
-					fmt.Printf("************\nSuccessfully produced record to topic %s partition [%d] @ offset %v\n*****************\n",
-						*ev.TopicPartition.Topic, ev.TopicPartition.Partition, ev.TopicPartition.Offset)
+					stats.Produced.Add(1)
+					messagesProduced.Inc()
 				}
+				onDelivery(ev, ev.TopicPartition.Error)
 			}
 		}
 	}()
 	return &KafkaProducer{
 		Producer:   p,
-		topic:      topic,
+		topic:      cfg.Topic,
 		chan_event: make(chan kafka.Event, 1000),
+		ttl:        cfg.MessageTTL,
+		keyFormat:  keyFormat,
+		router:     r,
+		maxBytes:   cfg.MaxMessageBytes,
+		retries:    cfg.QueueFullRetries,
 	}, nil
 }
 
-func (p *KafkaProducer) KafkaWrite(word []byte) {
-	// Produce messages to topic (asynchrjonously)
-	p.Producer.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+// Close waits up to timeout for outstanding deliveries and closes the
+// producer, logging how many records were still undelivered if the wait ran
+// out. It is meant for process shutdown; the producer can't be used
+// afterwards.
+func (p *KafkaProducer) Close(timeout time.Duration) {
+	if n := p.Producer.Flush(int(timeout.Milliseconds())); n > 0 {
+		slog.Warn("Closing producer with records undelivered", slog.Int("undelivered", n), slog.Duration("timeout", timeout))
+	}
+	p.Producer.Close()
+}
+
+// Ping fetches the topic's metadata to check the brokers are reachable.
+func (p *KafkaProducer) Ping(timeout time.Duration) error {
+	_, err := p.Producer.GetMetadata(&p.topic, false, int(timeout.Milliseconds()))
+	return err
+}
+
+// Key returns the record key for obuid in the configured format.
+func (p *KafkaProducer) Key(obuid int) []byte {
+	return p.keyFormat.Encode(obuid)
+}
+
+// KafkaWrite produces word without a key. It returns an error if the record
+// couldn't be queued; delivery failures are reported by the events goroutine.
+func (p *KafkaProducer) KafkaWrite(word []byte) error {
+	return p.KafkaWriteKeyed(nil, word)
+}
+
+// KafkaWriteWithHeaders produces value without a key, adding headers.
+func (p *KafkaProducer) KafkaWriteWithHeaders(value []byte, headers []Header) error {
+	return p.KafkaWriteKeyed(nil, value, headers...)
+}
+
+// KafkaWriteKeyed queues word for producing with the given record key and
+// headers, and returns without waiting for delivery, which the events
+// goroutine reports. Records sharing a key land on the same partition. Every
+// record also carries a producer-version header.
+func (p *KafkaProducer) KafkaWriteKeyed(key, word []byte, headers ...Header) error {
+	defer prometheus.NewTimer(produceDuration).ObserveDuration()
+	if size := len(key) + len(word); size > p.maxBytes {
+		oversized.Inc()
+		produceErrors.Inc()
+		stats.Errors.Add(1)
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrMessageTooLarge, size, p.maxBytes)
+	}
+	headers = append(headers[:len(headers):len(headers)], kafka.Header{
+		Key:   types.HeaderProducerVersion,
+		Value: []byte(producerVersion),
+	})
+	if p.ttl > 0 {
+		headers = append(headers, kafka.Header{
+			Key:   types.HeaderExpiresAt,
+			Value: types.FormatExpiresAt(time.Now().Add(p.ttl)),
+		})
+	}
+	partition := kafka.PartitionAny
+	if p.router != nil && key != nil {
+		partition = p.router.partition(key)
+	}
+	// Produce messages to topic (asynchronously)
+	err := p.produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &p.topic, Partition: partition},
+		Key:            key,
 		Value:          word,
-	}, nil)
+		Headers:        headers,
+	})
+	if err != nil {
+		if isQueueFull(err) {
+			slog.Warn("Producer queue full, dropping record")
+			queueFull.Inc()
+		}
+		produceErrors.Inc()
+		stats.Errors.Add(1)
+		return fmt.Errorf("%w: %w", ErrProduce, err)
+	}
+	return nil
+}
+
+// Bounds of the wait between retries of a write rejected with a full queue.
+const (
+	retryMinDelay = 10 * time.Millisecond
+	retryMaxDelay = time.Second
+)
+
+// produce enqueues msg, retrying with exponential backoff while the local
+// queue is full so deliveries can drain it. Other errors are returned at
+// once.
+func (p *KafkaProducer) produce(msg *kafka.Message) error {
+	return produceWithRetry(p.Producer, msg, p.retries)
+}
+
+// enqueuer is the part of *kafka.Producer produceWithRetry needs.
+type enqueuer interface {
+	Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error
+}
+
+// produceWithRetry is produce against q, retrying at most retries times.
+func produceWithRetry(q enqueuer, msg *kafka.Message, retries int) error {
+	delay := retryMinDelay
+	for attempt := 0; ; attempt++ {
+		err := q.Produce(msg, nil)
+		if !isQueueFull(err) || attempt == retries {
+			return err
+		}
+		time.Sleep(delay)
+		delay = min(delay*2, retryMaxDelay)
+	}
+}
 
-	// Wait for message deliveries before shutting down
-	p.Producer.Flush(15 * 1000)
+func isQueueFull(err error) bool {
+	var kerr kafka.Error
+	return errors.As(err, &kerr) && kerr.Code() == kafka.ErrQueueFull
 }
@@ -0,0 +1,322 @@
+package kafka
+
+import (
+	"errors"
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"github.com/erastusk/gpscords/config"
+)
+
+// loadFlags returns the Config ConfigFlags fills from args and the
+// environment.
+func loadFlags(t *testing.T, args ...string) Config {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := ConfigFlags(fs)
+	if err := config.Load(fs, "TEST", args); err != nil {
+		t.Fatal(err)
+	}
+	return *cfg
+}
+
+func TestConfigFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want Config
+	}{
+		{"defaults", nil, nil, Config{Brokers: DefaultBrokers, Topic: DefaultTopic, Compression: "none"}},
+		{
+			"legacy environment",
+			map[string]string{"KAFKA_BROKERS": "localhost:9092", "KAFKA_TOPIC": "gps-eu", "KAFKA_COMPRESSION": "zstd"},
+			nil,
+			Config{Brokers: "localhost:9092", Topic: "gps-eu", Compression: "zstd"},
+		},
+		{
+			"service environment over legacy",
+			map[string]string{"KAFKA_TOPIC": "legacy", "TEST_KAFKA_TOPIC": "gps-eu", "TEST_KAFKA_COMPRESSION": "lz4"},
+			nil,
+			Config{Brokers: DefaultBrokers, Topic: "gps-eu", Compression: "lz4"},
+		},
+		{
+			"command line over environment",
+			map[string]string{"TEST_KAFKA_BROKERS": "env:9092", "TEST_KAFKA_TOPIC": "env"},
+			[]string{"-kafka-brokers=flag:9092", "-kafka-topic=flag"},
+			Config{Brokers: "flag:9092", Topic: "flag", Compression: "none"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"KAFKA_BROKERS", "KAFKA_TOPIC", "KAFKA_COMPRESSION"} {
+				t.Setenv(key, tt.env[key])
+			}
+			for key, v := range tt.env {
+				t.Setenv(key, v)
+			}
+			cfg := loadFlags(t, tt.args...)
+			if cfg.Brokers != tt.want.Brokers || cfg.Topic != tt.want.Topic || cfg.Compression != tt.want.Compression {
+				t.Errorf("ConfigFlags = {Brokers: %q, Topic: %q, Compression: %q}, want {Brokers: %q, Topic: %q, Compression: %q}",
+					cfg.Brokers, cfg.Topic, cfg.Compression, tt.want.Brokers, tt.want.Topic, tt.want.Compression)
+			}
+		})
+	}
+}
+
+func TestSecurityConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want kafka.ConfigMap
+	}{
+		{"plaintext", nil, kafka.ConfigMap{}},
+		{
+			"sasl over tls",
+			map[string]string{
+				"KAFKA_SECURITY_PROTOCOL": "SASL_SSL",
+				"KAFKA_SASL_MECHANISM":    "SCRAM-SHA-512",
+				"KAFKA_SASL_USERNAME":     "receiver",
+				"KAFKA_SASL_PASSWORD":     "secret",
+				"KAFKA_SSL_CA_LOCATION":   "/etc/kafka/ca.pem",
+			},
+			kafka.ConfigMap{
+				"security.protocol": "SASL_SSL",
+				"sasl.mechanism":    "SCRAM-SHA-512",
+				"sasl.username":     "receiver",
+				"sasl.password":     "secret",
+				"ssl.ca.location":   "/etc/kafka/ca.pem",
+			},
+		},
+	}
+	securityKeys := []string{"security.protocol", "sasl.mechanism", "sasl.username", "sasl.password",
+		"ssl.ca.location", "ssl.certificate.location", "ssl.key.location"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"KAFKA_SECURITY_PROTOCOL", "KAFKA_SASL_MECHANISM", "KAFKA_SASL_USERNAME", "KAFKA_SASL_PASSWORD",
+				"KAFKA_SSL_CA_LOCATION", "KAFKA_SSL_CERT_LOCATION", "KAFKA_SSL_KEY_LOCATION"} {
+				t.Setenv(key, tt.env[key])
+			}
+			cm, err := configMap(loadFlags(t).withDefaults())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range securityKeys {
+				if got, want := cm[key], tt.want[key]; got != want {
+					t.Errorf("%s = %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompressionConfig(t *testing.T) {
+	tests := []struct {
+		compression string
+		want        string
+		wantErr     bool
+	}{
+		{"", "none", false},
+		{"none", "none", false},
+		{"gzip", "gzip", false},
+		{"snappy", "snappy", false},
+		{"lz4", "lz4", false},
+		{"zstd", "zstd", false},
+		{"brotli", "", true},
+		{"GZIP", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.compression, func(t *testing.T) {
+			cm, err := configMap(Config{Compression: tt.compression}.withDefaults())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("configMap error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && cm["compression.type"] != tt.want {
+				t.Errorf("compression.type = %v, want %s", cm["compression.type"], tt.want)
+			}
+			p, err := NewKafkaProducer(Config{Brokers: "127.0.0.1:1", Compression: tt.compression})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewKafkaProducer error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				p.Close(0)
+			}
+		})
+	}
+}
+
+func TestIdempotenceConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		disable bool
+		want    kafka.ConfigMap
+	}{
+		{"default", false, kafka.ConfigMap{"enable.idempotence": true, "acks": "all", "max.in.flight.requests.per.connection": 5}},
+		{"disabled", true, kafka.ConfigMap{"enable.idempotence": nil, "acks": nil, "max.in.flight.requests.per.connection": nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm, err := configMap(Config{DisableIdempotence: tt.disable}.withDefaults())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range tt.want {
+				if got := cm[key]; got != want {
+					t.Errorf("%s = %v, want %v", key, got, want)
+				}
+			}
+			// librdkafka rejects inconsistent settings at construction.
+			p, err := NewKafkaProducer(Config{Brokers: "127.0.0.1:1", DisableIdempotence: tt.disable})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Close(0)
+		})
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	t.Run("init", func(t *testing.T) {
+		_, err := NewKafkaProducer(Config{Brokers: "127.0.0.1:1", Security: config.KafkaSecurity{Protocol: "carrier-pigeon"}})
+		if !errors.Is(err, ErrProducerInit) {
+			t.Errorf("got error %v, want %v", err, ErrProducerInit)
+		}
+	})
+	t.Run("produce", func(t *testing.T) {
+		// Nothing is delivered, so the one-record queue stays full.
+		p, err := NewKafkaProducer(Config{Brokers: "127.0.0.1:1", MaxQueued: 1, OnDelivery: func(*Message, error) {}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.Close(0)
+		if err := p.KafkaWrite([]byte("first")); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.KafkaWrite([]byte("second")); !errors.Is(err, ErrProduce) {
+			t.Errorf("got error %v, want %v", err, ErrProduce)
+		}
+	})
+	t.Run("too large", func(t *testing.T) {
+		p, err := NewKafkaProducer(Config{Brokers: "127.0.0.1:1", MaxMessageBytes: 4, OnDelivery: func(*Message, error) {}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.Close(0)
+		if err := p.KafkaWrite([]byte("too large")); !errors.Is(err, ErrMessageTooLarge) {
+			t.Errorf("got error %v, want %v", err, ErrMessageTooLarge)
+		}
+	})
+}
+
+func TestOnDelivery(t *testing.T) {
+	mc, err := kafka.NewMockCluster(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	tests := []struct {
+		name    string
+		brokers string
+		// fail makes the queued record fail instead of waiting for it.
+		fail    func(p *KafkaProducer)
+		wantErr bool
+	}{
+		{"success", mc.BootstrapServers(), nil, false},
+		// Nothing listens, so the record stays queued until it is purged.
+		{"failure", "127.0.0.1:1", func(p *KafkaProducer) { p.Producer.Purge(kafka.PurgeQueue) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			type report struct {
+				msg *Message
+				err error
+			}
+			reports := make(chan report, 1)
+			p, err := NewKafkaProducer(Config{
+				Brokers:    tt.brokers,
+				Topic:      "gps-test",
+				OnDelivery: func(m *Message, err error) { reports <- report{m, err} },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close(0)
+			if err := p.KafkaWrite([]byte("reading")); err != nil {
+				t.Fatal(err)
+			}
+			if tt.fail != nil {
+				tt.fail(p)
+			}
+			select {
+			case r := <-reports:
+				if (r.err != nil) != tt.wantErr {
+					t.Errorf("OnDelivery got error %v, want error %v", r.err, tt.wantErr)
+				}
+				if string(r.msg.Value) != "reading" {
+					t.Errorf("OnDelivery got record %q, want %q", r.msg.Value, "reading")
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("OnDelivery not called")
+			}
+		})
+	}
+}
+
+func TestCloseTimesOut(t *testing.T) {
+	// Nothing listens, so the record is never delivered.
+	p, err := NewKafkaProducer(Config{Brokers: "127.0.0.1:1", OnDelivery: func(*Message, error) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.KafkaWrite([]byte("stalled")); err != nil {
+		t.Fatal(err)
+	}
+	const timeout = 200 * time.Millisecond
+	start := time.Now()
+	p.Close(timeout)
+	if took := time.Since(start); took > timeout+time.Second {
+		t.Errorf("Close took %v with a %v timeout", took, timeout)
+	}
+}
+
+// BenchmarkKafkaWrite compares queueing records and waiting for delivery once
+// at the end with the old flush after every record. Run with -benchtime=10000x
+// for a batch of 10k readings.
+func BenchmarkKafkaWrite(b *testing.B) {
+	mc, err := kafka.NewMockCluster(1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer mc.Close()
+	value := []byte(`{"obuid":42,"lat":48.8583701,"lon":2.2944813}`)
+	for _, bc := range []struct {
+		name         string
+		flushEachOne bool
+	}{
+		{"batched", false},
+		{"flush per message", true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			p, err := NewKafkaProducer(Config{Brokers: mc.BootstrapServers(), Topic: "gps-bench", OnDelivery: func(*Message, error) {}})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer p.Close(time.Second)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := p.KafkaWrite(value); err != nil {
+					b.Fatal(err)
+				}
+				if bc.flushEachOne {
+					p.Producer.Flush(10 * 1000)
+				}
+			}
+			if n := p.Producer.Flush(30 * 1000); n > 0 {
+				b.Fatalf("%d records undelivered", n)
+			}
+		})
+	}
+}
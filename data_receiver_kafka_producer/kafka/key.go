@@ -0,0 +1,48 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// KeyFormat selects how an OBUID is serialized into the record key.
+//
+// The default partitioner hashes the key bytes, so every format keeps all of
+// an OBU's records on one partition and therefore in order. The formats hash
+// differently though: switching format on a live topic moves OBUs between
+// partitions, and ordering across the switch is lost.
+type KeyFormat string
+
+const (
+	// KeyDecimal writes the OBUID as a decimal string, e.g. "42".
+	KeyDecimal KeyFormat = "decimal"
+	// KeyBinary writes the OBUID as 8 big-endian bytes. It is the most
+	// compact and, for non-negative ids, sorts numerically.
+	KeyBinary KeyFormat = "binary"
+	// KeyPadded writes the OBUID as a 20 character zero-padded decimal
+	// string, so keys sort lexically in numeric order.
+	KeyPadded KeyFormat = "padded"
+)
+
+// ParseKeyFormat validates s. An empty string selects KeyDecimal.
+func ParseKeyFormat(s string) (KeyFormat, error) {
+	switch f := KeyFormat(s); f {
+	case "":
+		return KeyDecimal, nil
+	case KeyDecimal, KeyBinary, KeyPadded:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown key format %q", s)
+}
+
+// Encode returns the record key for obuid.
+func (f KeyFormat) Encode(obuid int) []byte {
+	switch f {
+	case KeyBinary:
+		return binary.BigEndian.AppendUint64(nil, uint64(obuid))
+	case KeyPadded:
+		return []byte(fmt.Sprintf("%020d", obuid))
+	}
+	return strconv.AppendInt(nil, int64(obuid), 10)
+}
@@ -0,0 +1,52 @@
+package kafka
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKeyFormats(t *testing.T) {
+	tests := []struct {
+		format KeyFormat
+		want   []byte
+	}{
+		{KeyDecimal, []byte("42")},
+		{KeyBinary, []byte{0, 0, 0, 0, 0, 0, 0, 42}},
+		{KeyPadded, []byte("00000000000000000042")},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			a, b := tt.format.Encode(42), tt.format.Encode(42)
+			if !bytes.Equal(a, tt.want) {
+				t.Errorf("Encode(42) = %q, want %q", a, tt.want)
+			}
+			// Equal keys are what keep an OBU on one partition.
+			if !bytes.Equal(a, b) {
+				t.Errorf("two readings from OBU 42 got keys %q and %q", a, b)
+			}
+			if other := tt.format.Encode(43); bytes.Equal(a, other) {
+				t.Errorf("OBUs 42 and 43 share key %q", a)
+			}
+		})
+	}
+}
+
+func TestParseKeyFormat(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    KeyFormat
+		wantErr bool
+	}{
+		{"", KeyDecimal, false},
+		{"decimal", KeyDecimal, false},
+		{"binary", KeyBinary, false},
+		{"padded", KeyPadded, false},
+		{"hex", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseKeyFormat(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseKeyFormat(%q) = %q, %v, want %q and error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
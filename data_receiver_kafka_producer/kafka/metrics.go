@@ -0,0 +1,30 @@
+package kafka
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	messagesProduced = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kafka_messages_produced_total",
+		Help: "Records the brokers confirmed delivery of.",
+	})
+	produceErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kafka_produce_errors_total",
+		Help: "Records rejected locally or that failed delivery.",
+	})
+	produceDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kafka_produce_duration_seconds",
+		Help:    "Time taken by KafkaWrite to queue a record for producing.",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+	})
+	queueFull = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "produce_queue_full_total",
+		Help: "Records rejected because the local producer queue was full.",
+	})
+	oversized = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "produce_oversized_total",
+		Help: "Records rejected because they exceeded the maximum message size.",
+	})
+)
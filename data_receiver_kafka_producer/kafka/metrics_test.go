@@ -0,0 +1,76 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrape returns the value of a counter, or the sample count of a histogram,
+// as the default registry would serve it.
+func scrape(t *testing.T, name string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		m := f.GetMetric()[0]
+		if h := m.GetHistogram(); h != nil {
+			return float64(h.GetSampleCount())
+		}
+		return m.GetCounter().GetValue()
+	}
+	t.Fatalf("%s not registered", name)
+	return 0
+}
+
+func TestMetricsAfterProducing(t *testing.T) {
+	mc, err := kafka.NewMockCluster(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	delivered := make(chan error, 1)
+	p, err := NewKafkaProducer(Config{
+		Brokers:    mc.BootstrapServers(),
+		Topic:      "gps-test",
+		OnDelivery: func(_ *Message, err error) { delivered <- err },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close(time.Second)
+
+	names := []string{"kafka_messages_produced_total", "kafka_produce_duration_seconds", "kafka_produce_errors_total"}
+	before := map[string]float64{}
+	for _, name := range names {
+		before[name] = scrape(t, name)
+	}
+	if err := p.KafkaWrite([]byte(`{"obuid":1,"lat":1,"lon":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-delivered:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("record not delivered")
+	}
+	// The counter is bumped just before OnDelivery is called.
+	for name, want := range map[string]float64{
+		"kafka_messages_produced_total":  1,
+		"kafka_produce_duration_seconds": 1,
+		"kafka_produce_errors_total":     0,
+	} {
+		if got := scrape(t, name) - before[name]; got != want {
+			t.Errorf("%s rose by %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// fakeQueue fails its first fails Produce calls with err.
+type fakeQueue struct {
+	fails int
+	err   error
+	calls int
+}
+
+func (q *fakeQueue) Produce(*kafka.Message, chan kafka.Event) error {
+	q.calls++
+	if q.calls <= q.fails {
+		return q.err
+	}
+	return nil
+}
+
+func TestProduceWithRetry(t *testing.T) {
+	queueFull := kafka.NewError(kafka.ErrQueueFull, "queue full", false)
+	tests := []struct {
+		name      string
+		fails     int
+		err       error
+		retries   int
+		wantErr   bool
+		wantCalls int
+	}{
+		{"succeeds at once", 0, nil, 5, false, 1},
+		{"queue full twice", 2, queueFull, 5, false, 3},
+		{"queue full past the retries", 10, queueFull, 2, true, 3},
+		{"no retries", 1, queueFull, 0, true, 1},
+		{"other errors aren't retried", 1, errors.New("unknown topic"), 5, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &fakeQueue{fails: tt.fails, err: tt.err}
+			err := produceWithRetry(q, &kafka.Message{}, tt.retries)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("produceWithRetry error = %v, want error %v", err, tt.wantErr)
+			}
+			if q.calls != tt.wantCalls {
+				t.Errorf("Produce called %d times, want %d", q.calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package kafka
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// partitionRefresh is how often the partition count is re-read so added
+// partitions start receiving traffic.
+const partitionRefresh = time.Minute
+
+// jumpHash maps key onto one of n buckets with Lamping and Veach's jump
+// consistent hash. Growing n to n+1 moves only about 1/(n+1) of the keys, all
+// of them onto the new bucket. Hashing modulo n would instead move nearly
+// every key, reordering almost every OBU's stream whenever partitions are
+// added.
+func jumpHash(key uint64, n int) int32 {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int32(b)
+}
+
+// router picks partitions for keyed records by consistent hashing over the
+// topic's current partition count.
+type router struct {
+	partitions atomic.Int32
+}
+
+func newRouter(p *kafka.Producer, topic string) (*router, error) {
+	r := &router{}
+	if err := r.refresh(p, topic); err != nil {
+		return nil, err
+	}
+	go func() {
+		for range time.Tick(partitionRefresh) {
+			if err := r.refresh(p, topic); err != nil {
+				slog.Error("Couldn't refresh partition count", slog.String("topic", topic), slog.Any("err", err))
+			}
+		}
+	}()
+	return r, nil
+}
+
+func (r *router) refresh(p *kafka.Producer, topic string) error {
+	md, err := p.GetMetadata(&topic, false, 10*1000)
+	if err != nil {
+		return err
+	}
+	n := len(md.Topics[topic].Partitions)
+	if n == 0 {
+		return fmt.Errorf("topic %q has no partitions", topic)
+	}
+	if old := r.partitions.Swap(int32(n)); old != 0 && old != int32(n) {
+		slog.Info("Partition count changed", slog.String("topic", topic), slog.Int("partitions", n), slog.Int("was", int(old)))
+	}
+	return nil
+}
+
+// partition returns the partition for key.
+func (r *router) partition(key []byte) int32 {
+	h := fnv.New64a()
+	h.Write(key)
+	return jumpHash(h.Sum64(), int(r.partitions.Load()))
+}
@@ -0,0 +1,21 @@
+package kafka
+
+import "runtime/debug"
+
+// producerVersion identifies this build in the producer-version header: the
+// module version, or the VCS revision for development builds.
+var producerVersion = func() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := bi.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, s := range bi.Settings {
+		if s.Key == "vcs.revision" {
+			return s.Value
+		}
+	}
+	return "devel"
+}()
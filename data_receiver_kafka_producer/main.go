@@ -1,20 +1,163 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/erastusk/gpscords/config"
 	"github.com/erastusk/gpscords/data_receiver_kafka_producer/handlers"
+	"github.com/erastusk/gpscords/data_receiver_kafka_producer/kafka"
+	"github.com/erastusk/gpscords/logging"
+	"github.com/erastusk/gpscords/stats"
+	"github.com/erastusk/gpscords/types"
 )
 
-var addr = flag.String("addr", "localhost:30000", "http service address")
+var (
+	addr          = flag.String("addr", "localhost:30000", "http service address")
+	basePath      = flag.String("base-path", "", "prefix for every route, e.g. /gps when served behind a reverse proxy")
+	messageTTL    = flag.Duration("message-ttl", 0, "expire produced records after this long (0 disables)")
+	maxSpeed      = flag.Float64("max-speed", 0, "reject readings implying a speed above this many m/s (0 disables)")
+	speedAct      = flag.String("speed-action", handlers.SpeedActionDrop, "what to do with implausible readings: drop or flag")
+	speedOBUs     = flag.Int("speed-max-obus", 10000, "number of OBUs whose last position is kept for the speed check")
+	rateLimit     = flag.Float64("rate-limit", 0, "readings per second accepted from each OBU, dropping the excess (0 disables)")
+	rateBurst     = flag.Int("rate-burst", 10, "readings an OBU may send at once before -rate-limit applies")
+	rateOBUs      = flag.Int("rate-limit-obus", 10000, "number of OBUs whose rate is tracked")
+	keyFormat     = flag.String("key-format", string(kafka.KeyDecimal), "record key format for OBUIDs: decimal, binary or padded")
+	consistent    = flag.Bool("consistent-routing", false, "route OBUs to partitions by consistent hashing so adding partitions moves few OBUs")
+	maxMsgSize    = flag.Int("max-message-bytes", kafka.DefaultMaxMessageBytes, "reject records larger than this before producing")
+	linger        = flag.Duration("linger", 5*time.Millisecond, "how long records wait to be batched before sending")
+	batchSize     = flag.Int("batch-size", 0, "maximum bytes per produce batch (0 keeps the librdkafka default)")
+	maxQueued     = flag.Int("max-queued", 100000, "records awaiting delivery before writes are rejected as queue full")
+	idempotent    = flag.Bool("idempotent", true, "produce idempotently so retries never duplicate records (requires acks from all in-sync replicas)")
+	retries       = flag.Int("queue-full-retries", 5, "retry writes this many times, with backoff, while the producer queue is full")
+	useNumber     = flag.Bool("use-number", false, "decode numbers from their literal text and reject out-of-range OBUIDs")
+	acceptE7      = flag.Bool("accept-e7", false, "accept legacy lat_e7/lon_e7 integer coordinates scaled by 1e7")
+	strict        = flag.Bool("strict", false, "reject JSON readings with unknown fields or without obuid, lat and lon")
+	heartbeat     = flag.Int("heartbeat-obuid", 0, "sentinel OBUID of heartbeat readings, normally -1 (0 disables)")
+	readLimit     = flag.Int64("read-limit", handlers.ReadLimit, "close connections sending a message larger than this many bytes (0 disables)")
+	readBuffer    = flag.Int("read-buffer", 1024, "WebSocket read buffer size in bytes")
+	writeBuffer   = flag.Int("write-buffer", 1024, "WebSocket write buffer size in bytes")
+	batchReads    = flag.Int("batch-readings", 0, "produce up to this many readings per OBU as one JSON array record (0 disables batching)")
+	batchWindow   = flag.Duration("batch-window", handlers.BatchWindow, "longest a reading waits for its batch to fill")
+	pongWait      = flag.Duration("pong-wait", handlers.PongWait, "close client connections that don't answer a ping within this long (0 disables keepalive)")
+	flushTimeout  = flag.Duration("flush-timeout", 15*time.Second, "how long shutdown waits for produced records to be delivered")
+	shutdownGrace = flag.Duration("shutdown-grace", 10*time.Second, "how long clients get to disconnect on shutdown before being cut off")
+
+	kafkaCfg      = kafka.ConfigFlags(flag.CommandLine)
+	serialization = config.SerializationFlags(flag.CommandLine)
+)
 
 func main() {
-	http.HandleFunc("/ws", handlers.ReceiveWs)
-	http.Handle("/metrics", promhttp.Handler())
-	log.Println("starting server")
-	log.Fatal(http.ListenAndServe(*addr, nil))
+	logging.Setup("receiver")
+	config.MustLoad("RECEIVER")
+	kf, err := kafka.ParseKeyFormat(*keyFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg := *kafkaCfg
+	cfg.MessageTTL = *messageTTL
+	cfg.KeyFormat = kf
+	cfg.ConsistentRouting = *consistent
+	cfg.MaxMessageBytes = *maxMsgSize
+	cfg.Linger = *linger
+	cfg.BatchSize = *batchSize
+	cfg.MaxQueued = *maxQueued
+	cfg.DisableIdempotence = !*idempotent
+	cfg.QueueFullRetries = *retries
+	if handlers.Codec, err = serialization.Codec(cfg.Topic); err != nil {
+		log.Fatal(err)
+	}
+	k, err := kafka.NewKafkaProducer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stats.OnShutdown(func() { k.Close(*flushTimeout) })
+	handlers.UseNumber = *useNumber
+	handlers.AcceptE7 = *acceptE7
+	handlers.Strict = *strict
+	handlers.HeartbeatOBUID = *heartbeat
+	handlers.PongWait = *pongWait
+	if *batchReads > 0 {
+		if _, ok := handlers.Codec.(types.JSONCodec); !ok {
+			log.Fatal("-batch-readings needs the json serialization")
+		}
+		handlers.BatchSize = *batchReads
+		handlers.BatchWindow = *batchWindow
+	}
+	handlers.ReadLimit = *readLimit
+	handlers.SetBufferSizes(*readBuffer, *writeBuffer)
+	if v := os.Getenv("ALLOWED_ORIGINS"); v != "" {
+		handlers.AllowOrigins(strings.Split(v, ","))
+	}
+	if *maxSpeed > 0 {
+		sc, err := handlers.NewSpeedChecker(*maxSpeed, *speedAct, *speedOBUs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		handlers.SpeedCheck = sc
+	}
+	if *rateLimit > 0 {
+		rl, err := handlers.NewRateLimiter(*rateLimit, *rateBurst, *rateOBUs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		handlers.RateLimit = rl
+	}
+	prefix := normalizeBasePath(*basePath)
+	http.HandleFunc(prefix+"/ws", handlers.ReceiveWs(k))
+	http.Handle(prefix+"/metrics", promhttp.Handler())
+	http.HandleFunc(prefix+"/healthz", handlers.Healthz)
+	http.HandleFunc(prefix+"/readyz", handlers.Readyz(func() error { return k.Ping(2 * time.Second) }))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	serve(ctx, &http.Server{}, ln, *shutdownGrace)
+}
+
+// serve serves srv on ln until ctx is done, then shuts down: it stops
+// accepting connections, gives clients up to grace to go away, and runs the
+// shutdown hooks, which flush the producer.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, grace time.Duration) {
+	go func() {
+		slog.Info("Starting server", slog.String("addr", ln.Addr().String()))
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+	<-ctx.Done()
+	slog.Info("Shutting down")
+	sctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		slog.Error("HTTP shutdown failed", slog.Any("err", err))
+	}
+	handlers.CloseConnections(sctx)
+	// Flushes the producer through its shutdown hook.
+	stats.Shutdown("receiver")
+}
+
+// normalizeBasePath turns "gps", "/gps" and "/gps/" into "/gps", and "" or
+// "/" into "".
+func normalizeBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
 }
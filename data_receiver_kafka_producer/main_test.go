@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	confluent "github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/gorilla/websocket"
+
+	"github.com/erastusk/gpscords/data_receiver_kafka_producer/handlers"
+	"github.com/erastusk/gpscords/data_receiver_kafka_producer/kafka"
+	"github.com/erastusk/gpscords/stats"
+)
+
+func TestShutdownFlushesProducer(t *testing.T) {
+	mc, err := confluent.NewMockCluster(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	var delivered atomic.Int64
+	k, err := kafka.NewKafkaProducer(kafka.Config{
+		Brokers: mc.BootstrapServers(),
+		Topic:   "gps-test",
+		// Long enough that the record is still queued at SIGTERM.
+		Linger: 2 * time.Second,
+		OnDelivery: func(_ *kafka.Message, err error) {
+			if err == nil {
+				delivered.Add(1)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats.OnShutdown(func() { k.Close(10 * time.Second) })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+	done := make(chan struct{})
+	go func() {
+		serve(ctx, &http.Server{Handler: handlers.ReceiveWs(k)}, ln, 5*time.Second)
+		close(done)
+	}()
+
+	c, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WriteJSON(map[string]any{"obuid": 1, "lat": 1, "lon": 2}); err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("serve didn't return after SIGTERM")
+	}
+	if n := delivered.Load(); n != 1 {
+		t.Errorf("%d records delivered by the time serve returned, want 1", n)
+	}
+	if err := <-closed; !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("client saw %v, want a going-away close", err)
+	}
+}
@@ -0,0 +1,49 @@
+// Package geo holds the great-circle math shared by the services, so speed,
+// distance, geofence and ETA features agree on a single implementation.
+// Coordinates are decimal degrees and distances are meters on a spherical
+// Earth, which is accurate to within about 0.5%.
+package geo
+
+import "math"
+
+// EarthRadius is the mean Earth radius in meters.
+const EarthRadius = 6371008.8
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// Haversine returns the great-circle distance in meters between two points
+// given in decimal degrees.
+func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := radians(lat2 - lat1)
+	dLon := radians(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(radians(lat1))*math.Cos(radians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// Bearing returns the initial great-circle bearing in degrees clockwise from
+// north, in the range [0, 360), for travelling from the first point to the
+// second.
+func Bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	φ1, φ2 := radians(lat1), radians(lat2)
+	dLon := radians(lon2 - lon1)
+	y := math.Sin(dLon) * math.Cos(φ2)
+	x := math.Cos(φ1)*math.Sin(φ2) - math.Sin(φ1)*math.Cos(φ2)*math.Cos(dLon)
+	deg := math.Atan2(y, x) * 180 / math.Pi
+	return math.Mod(deg+360, 360)
+}
+
+// Destination returns the point reached by travelling dist meters from the
+// given point along the initial bearing, in degrees clockwise from north.
+// The longitude is normalized to [-180, 180).
+func Destination(lat, lon, bearing, dist float64) (float64, float64) {
+	φ1, λ1 := radians(lat), radians(lon)
+	θ := radians(bearing)
+	δ := dist / EarthRadius
+	φ2 := math.Asin(math.Sin(φ1)*math.Cos(δ) + math.Cos(φ1)*math.Sin(δ)*math.Cos(θ))
+	λ2 := λ1 + math.Atan2(math.Sin(θ)*math.Sin(δ)*math.Cos(φ1), math.Cos(δ)-math.Sin(φ1)*math.Sin(φ2))
+	lon2 := math.Mod(λ2*180/math.Pi+540, 360) - 180
+	return φ2 * 180 / math.Pi, lon2
+}
@@ -0,0 +1,83 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+type place struct{ lat, lon float64 }
+
+var (
+	london     = place{51.5074, -0.1278}
+	paris      = place{48.8566, 2.3522}
+	newYork    = place{40.7128, -74.0060}
+	losAngeles = place{34.0522, -118.2437}
+	sydney     = place{-33.8688, 151.2093}
+	melbourne  = place{-37.8136, 144.9631}
+	tokyo      = place{35.6762, 139.6503}
+	sanFran    = place{37.7749, -122.4194}
+	suva       = place{-18.1248, 178.4501}
+	apia       = place{-13.8333, -171.7667}
+)
+
+// cityPairs holds published great-circle distances and initial bearings.
+var cityPairs = []struct {
+	name     string
+	from, to place
+	km       float64
+	bearing  float64
+}{
+	{"London to Paris", london, paris, 343.5, 148.1},
+	{"New York to Los Angeles", newYork, losAngeles, 3936, 273.7},
+	{"Sydney to Melbourne", sydney, melbourne, 713.4, 230.3},
+	{"Tokyo to San Francisco, across the antimeridian", tokyo, sanFran, 8275, 54.4},
+	{"Suva to Apia, across the antimeridian", suva, apia, 1149, 66.9},
+	{"one degree east across the antimeridian at the equator", place{0, 179.5}, place{0, -179.5}, 111.2, 90},
+	{"identical points", paris, paris, 0, 0},
+}
+
+func TestHaversine(t *testing.T) {
+	for _, tt := range cityPairs {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Haversine(tt.from.lat, tt.from.lon, tt.to.lat, tt.to.lon) / 1000
+			// Allow for the spherical model and rounding of the references.
+			if tol := math.Max(0.005*tt.km, 0.1); math.Abs(got-tt.km) > tol {
+				t.Errorf("Haversine = %.1f km, want %.1f ± %.1f", got, tt.km, tol)
+			}
+			back := Haversine(tt.to.lat, tt.to.lon, tt.from.lat, tt.from.lon) / 1000
+			if math.Abs(back-got) > 1e-9 {
+				t.Errorf("Haversine isn't symmetric: %v one way, %v back", got, back)
+			}
+		})
+	}
+}
+
+func TestBearing(t *testing.T) {
+	for _, tt := range cityPairs {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Bearing(tt.from.lat, tt.from.lon, tt.to.lat, tt.to.lon)
+			if got < 0 || got >= 360 {
+				t.Fatalf("Bearing = %v, want it in [0, 360)", got)
+			}
+			if math.Abs(got-tt.bearing) > 0.5 {
+				t.Errorf("Bearing = %.1f°, want %.1f°", got, tt.bearing)
+			}
+		})
+	}
+}
+
+func TestDestinationInvertsHaversine(t *testing.T) {
+	for _, tt := range cityPairs {
+		t.Run(tt.name, func(t *testing.T) {
+			dist := Haversine(tt.from.lat, tt.from.lon, tt.to.lat, tt.to.lon)
+			bearing := Bearing(tt.from.lat, tt.from.lon, tt.to.lat, tt.to.lon)
+			lat, lon := Destination(tt.from.lat, tt.from.lon, bearing, dist)
+			if lon < -180 || lon >= 180 {
+				t.Errorf("Destination longitude %v outside [-180, 180)", lon)
+			}
+			if miss := Haversine(lat, lon, tt.to.lat, tt.to.lon); miss > 1 {
+				t.Errorf("Destination = (%v, %v), %.1f m from (%v, %v)", lat, lon, miss, tt.to.lat, tt.to.lon)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/erastusk/gpscords/kafka_reader/kafka"
+	"github.com/erastusk/gpscords/types"
+)
+
+// injectHandler serves POST /debug/inject, pushing the posted reading to the
+// consumer's handlers as if it came from Kafka. Anyone who can reach it can
+// feed arbitrary data downstream, so it is only registered with
+// -enable-debug.
+func injectHandler(c *kafka.KafkaConsumer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var t types.SourceCoords
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := t.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := c.Inject(t); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		slog.Info("Injected debug reading", slog.Int("obuid", t.OBUID),
+			slog.Float64("lat", t.Lat), slog.Float64("lon", t.Lon), slog.Time("timestamp", t.Timestamp))
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
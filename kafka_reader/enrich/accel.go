@@ -0,0 +1,84 @@
+package enrich
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+// Acceleration event types.
+const (
+	HarshAcceleration = "harsh_acceleration"
+	HarshBraking      = "harsh_braking"
+)
+
+// AccelEvent reports a speed change steeper than the configured threshold.
+type AccelEvent struct {
+	Type      string    `json:"type"`
+	OBUID     int       `json:"obuid"`
+	Accel     float64   `json:"accel_mps2"`
+	SpeedFrom float64   `json:"speed_from_mps"`
+	SpeedTo   float64   `json:"speed_to_mps"`
+	At        time.Time `json:"at"`
+}
+
+type accelSample struct {
+	t     types.SourceCoords
+	speed *float64
+}
+
+// AccelDetector derives acceleration from consecutive speeds and reports
+// harsh acceleration and braking. GPS noise makes speeds over very short
+// intervals meaningless, so readings closer than minDelta to the previous
+// sample are skipped and the next one is measured over the longer span.
+type AccelDetector struct {
+	mu        sync.Mutex
+	threshold float64
+	minDelta  time.Duration
+	emit      func(AccelEvent)
+	last      map[int]accelSample
+}
+
+// NewAccelDetector reports accelerations of at least threshold m/s² to emit.
+func NewAccelDetector(threshold float64, minDelta time.Duration, emit func(AccelEvent)) *AccelDetector {
+	return &AccelDetector{
+		threshold: threshold,
+		minDelta:  minDelta,
+		emit:      emit,
+		last:      make(map[int]accelSample),
+	}
+}
+
+// Observe feeds a consumed reading to the detector. Readings without a
+// timestamp are ignored.
+func (d *AccelDetector) Observe(t types.SourceCoords) {
+	if t.Timestamp.IsZero() {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prev, ok := d.last[t.OBUID]
+	if !ok {
+		d.last[t.OBUID] = accelSample{t: t}
+		return
+	}
+	dt := t.Timestamp.Sub(prev.t.Timestamp)
+	if dt < d.minDelta || dt <= 0 {
+		return
+	}
+	e := types.Enrich(t, &prev.t)
+	if e.Speed != nil && prev.speed != nil {
+		accel := (*e.Speed - *prev.speed) / dt.Seconds()
+		if math.Abs(accel) >= d.threshold {
+			typ := HarshAcceleration
+			if accel < 0 {
+				typ = HarshBraking
+			}
+			d.emit(AccelEvent{Type: typ, OBUID: t.OBUID, Accel: accel,
+				SpeedFrom: *prev.speed, SpeedTo: *e.Speed, At: t.Timestamp})
+		}
+	}
+	d.last[t.OBUID] = accelSample{t: t, speed: e.Speed}
+}
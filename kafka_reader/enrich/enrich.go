@@ -0,0 +1,48 @@
+package enrich
+
+import (
+	"sync"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+// Enricher remembers the last reading per OBU and derives speed and heading
+// for each new one.
+type Enricher struct {
+	mu   sync.Mutex
+	last map[int]types.SourceCoords
+}
+
+func NewEnricher() *Enricher {
+	return &Enricher{last: make(map[int]types.SourceCoords)}
+}
+
+// Enrich returns t with whichever derived fields are computable. A reading
+// timestamped before the OBU's latest one arrived out of order: it gets no
+// derived fields and doesn't replace the latest reading.
+func (e *Enricher) Enrich(t types.SourceCoords) types.Enriched {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var prev *types.SourceCoords
+	if p, ok := e.last[t.OBUID]; ok {
+		if t.Timestamp.Before(p.Timestamp) {
+			return types.Enriched{SourceCoords: t}
+		}
+		prev = &p
+	}
+	e.last[t.OBUID] = t
+	return types.Enrich(t, prev)
+}
+
+// Run enriches every reading received on in and sends the results, in the
+// same order, on the returned channel. The channel is closed once in is.
+func (e *Enricher) Run(in <-chan types.SourceCoords) <-chan types.Enriched {
+	out := make(chan types.Enriched, cap(in))
+	go func() {
+		defer close(out)
+		for t := range in {
+			out <- e.Enrich(t)
+		}
+	}()
+	return out
+}
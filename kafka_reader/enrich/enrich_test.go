@@ -0,0 +1,54 @@
+package enrich
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+func TestEnrichSpeed(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	first := types.SourceCoords{OBUID: 1, Lat: 48.85, Lon: 2.29, Timestamp: t0}
+	// 0.001° of latitude is 111.195m, covered here in 10s.
+	moved := types.SourceCoords{OBUID: 1, Lat: 48.851, Lon: 2.29, Timestamp: t0.Add(10 * time.Second)}
+	wantSpeed := 11.1195
+
+	tests := []struct {
+		name    string
+		in      types.SourceCoords
+		speed   *float64
+		heading *float64
+	}{
+		{"first reading", first, nil, nil},
+		{"moved north", moved, &wantSpeed, ptr(0)},
+		{"other OBU", types.SourceCoords{OBUID: 2, Lat: 1, Lon: 1, Timestamp: t0}, nil, nil},
+		{"out of order", types.SourceCoords{OBUID: 1, Lat: 48.86, Lon: 2.29, Timestamp: t0.Add(5 * time.Second)}, nil, nil},
+		{"stationary", types.SourceCoords{OBUID: 1, Lat: 48.851, Lon: 2.29, Timestamp: t0.Add(20 * time.Second)}, ptr(0), nil},
+	}
+	e := NewEnricher()
+	for _, tt := range tests {
+		got := e.Enrich(tt.in)
+		if !near(got.Speed, tt.speed) || !near(got.Heading, tt.heading) {
+			t.Errorf("%s: got speed %v and heading %v, want %v and %v", tt.name, show(got.Speed), show(got.Heading), show(tt.speed), show(tt.heading))
+		}
+	}
+}
+
+func ptr(v float64) *float64 { return &v }
+
+// near reports whether a and b are both nil or within 1e-3 of each other.
+func near(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return math.Abs(*a-*b) < 1e-3
+}
+
+func show(v *float64) any {
+	if v == nil {
+		return "unset"
+	}
+	return *v
+}
@@ -0,0 +1,199 @@
+package eta
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erastusk/gpscords/geo"
+	"github.com/erastusk/gpscords/types"
+)
+
+// Status values reported alongside an Estimate.
+const (
+	StatusApproaching = "approaching"
+	StatusArrived     = "arrived"
+	StatusReceding    = "receding"
+	StatusStationary  = "stationary"
+	StatusStale       = "stale"
+)
+
+// minSpeed is the speed in m/s below which an OBU is treated as stationary,
+// so GPS jitter doesn't produce absurd ETAs.
+const minSpeed = 0.5
+
+// Fence is a circular destination area.
+type Fence struct {
+	Lat    float64
+	Lon    float64
+	Radius float64 // meters
+}
+
+// ParseFence parses "lat,lon,radius", with lat and lon in range and a
+// positive radius.
+func ParseFence(s string) (Fence, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return Fence{}, fmt.Errorf("fence %q: want lat,lon,radius", s)
+	}
+	var v [3]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return Fence{}, fmt.Errorf("fence %q: %w", s, err)
+		}
+		v[i] = f
+	}
+	f := Fence{Lat: v[0], Lon: v[1], Radius: v[2]}
+	switch {
+	case !(f.Lat >= -90 && f.Lat <= 90):
+		return Fence{}, fmt.Errorf("fence %q: latitude outside -90..90", s)
+	case !(f.Lon >= -180 && f.Lon <= 180):
+		return Fence{}, fmt.Errorf("fence %q: longitude outside -180..180", s)
+	case !(f.Radius > 0):
+		return Fence{}, fmt.Errorf("fence %q: radius is not positive", s)
+	}
+	return f, nil
+}
+
+// Estimate is the arrival estimate for one OBU.
+type Estimate struct {
+	OBUID      int      `json:"obuid"`
+	Status     string   `json:"status"`
+	Distance   float64  `json:"distance_m"`
+	Speed      float64  `json:"speed_mps"`
+	ETASeconds *float64 `json:"eta_seconds,omitempty"`
+}
+
+type track struct {
+	last    types.SourceCoords
+	speed   float64
+	heading float64
+}
+
+// Estimator tracks OBU movement and estimates when each will reach the
+// destination fence.
+type Estimator struct {
+	mu         sync.Mutex
+	dest       Fence
+	staleAfter time.Duration
+	tracks     map[int]*track
+}
+
+// NewEstimator returns an Estimator for dest. Positions timestamped more than
+// staleAfter ago produce no estimate.
+func NewEstimator(dest Fence, staleAfter time.Duration) *Estimator {
+	return &Estimator{
+		dest:       dest,
+		staleAfter: staleAfter,
+		tracks:     make(map[int]*track),
+	}
+}
+
+// Observe records a consumed reading. Speed is derived from the readings'
+// timestamps rather than when they were consumed, so a replayed backlog moves
+// at the pace it was recorded. A reading without a timestamp is taken to be
+// current.
+func (e *Estimator) Observe(t types.SourceCoords) {
+	now := time.Now()
+	if t.Timestamp.IsZero() {
+		t.Timestamp = now
+	}
+	e.observe(t)
+	if est, ok := e.Estimate(t.OBUID, now); ok && est.ETASeconds != nil {
+		slog.Info("ETA", slog.Int("obuid", t.OBUID),
+			slog.Duration("eta", time.Duration(*est.ETASeconds*float64(time.Second)).Round(time.Second)),
+			slog.Float64("distance_m", est.Distance))
+	}
+}
+
+// observe updates t's track. A reading older than the latest one arrived out
+// of order and is ignored.
+func (e *Estimator) observe(t types.SourceCoords) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	tr, ok := e.tracks[t.OBUID]
+	if !ok {
+		e.tracks[t.OBUID] = &track{last: t}
+		return
+	}
+	if t.Timestamp.Before(tr.last.Timestamp) {
+		return
+	}
+	en := types.Enrich(t, &tr.last)
+	if en.Speed != nil {
+		tr.speed = *en.Speed
+		if tr.speed >= minSpeed && en.Heading != nil {
+			tr.heading = *en.Heading
+		}
+	}
+	tr.last = t
+}
+
+// Estimate returns the current estimate for obuid, or false if the OBU has
+// never been seen.
+func (e *Estimator) Estimate(obuid int, now time.Time) (Estimate, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	tr, ok := e.tracks[obuid]
+	if !ok {
+		return Estimate{}, false
+	}
+	dist := math.Max(0, geo.Haversine(tr.last.Lat, tr.last.Lon, e.dest.Lat, e.dest.Lon)-e.dest.Radius)
+	est := Estimate{OBUID: obuid, Distance: dist, Speed: tr.speed}
+	if now.Sub(tr.last.Timestamp) > e.staleAfter {
+		est.Status = StatusStale
+		return est, true
+	}
+	if dist == 0 {
+		est.Status = StatusArrived
+		zero := 0.0
+		est.ETASeconds = &zero
+		return est, true
+	}
+	if tr.speed < minSpeed {
+		est.Status = StatusStationary
+		return est, true
+	}
+	toward := geo.Bearing(tr.last.Lat, tr.last.Lon, e.dest.Lat, e.dest.Lon)
+	closing := tr.speed * math.Cos((tr.heading-toward)*math.Pi/180)
+	if closing < minSpeed {
+		est.Status = StatusReceding
+		return est, true
+	}
+	secs := dist / closing
+	est.Status = StatusApproaching
+	est.ETASeconds = &secs
+	return est, true
+}
+
+// ServeHTTP serves GET /obus/{id}/eta.
+func (e *Estimator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rest, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/obus/"), "/eta")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(rest)
+	if err != nil {
+		http.Error(w, "invalid OBU id", http.StatusBadRequest)
+		return
+	}
+	est, ok := e.Estimate(id, time.Now())
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(est)
+}
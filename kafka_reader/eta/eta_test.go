@@ -0,0 +1,82 @@
+package eta
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+func TestParseFence(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Fence
+		wantErr bool
+	}{
+		{"48.85, 2.29, 500", Fence{Lat: 48.85, Lon: 2.29, Radius: 500}, false},
+		{"-90,180,1", Fence{Lat: -90, Lon: 180, Radius: 1}, false},
+		{"48.85,2.29", Fence{}, true},
+		{"north,2.29,500", Fence{}, true},
+		{"91,2.29,500", Fence{}, true},
+		{"48.85,-181,500", Fence{}, true},
+		{"48.85,2.29,0", Fence{}, true},
+		{"48.85,2.29,-5", Fence{}, true},
+		{"48.85,2.29,NaN", Fence{}, true},
+		{"NaN,2.29,500", Fence{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseFence(tt.in)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseFence(%q) = %+v, %v, want %+v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEstimateUsesReadingTimestamps(t *testing.T) {
+	// Two readings 0.009 degrees of latitude, about 1 km, and 100 s apart,
+	// heading due north towards a fence 10 km away.
+	t0 := time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)
+	first := types.SourceCoords{OBUID: 1, Lat: 0, Lon: 0, Timestamp: t0}
+	second := types.SourceCoords{OBUID: 1, Lat: 0.009, Lon: 0, Timestamp: t0.Add(100 * time.Second)}
+	tests := []struct {
+		name       string
+		now        time.Time
+		wantStatus string
+	}{
+		{"live", second.Timestamp.Add(time.Second), StatusApproaching},
+		// A replayed backlog is consumed long after it was recorded.
+		{"replayed", second.Timestamp.Add(time.Hour), StatusStale},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEstimator(Fence{Lat: 0.1, Lon: 0, Radius: 100}, time.Minute)
+			// Both are observed in the same instant, as when catching up.
+			e.observe(first)
+			e.observe(second)
+			est, ok := e.Estimate(1, tt.now)
+			if !ok {
+				t.Fatal("no estimate")
+			}
+			if math.Abs(est.Speed-10) > 0.1 {
+				t.Errorf("speed %.2f m/s, want about 10", est.Speed)
+			}
+			if est.Status != tt.wantStatus {
+				t.Errorf("status %s, want %s", est.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestOutOfOrderReadingIgnored(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)
+	e := NewEstimator(Fence{Lat: 1, Lon: 0, Radius: 100}, time.Minute)
+	e.observe(types.SourceCoords{OBUID: 1, Lat: 0.5, Timestamp: t0.Add(time.Minute)})
+	e.observe(types.SourceCoords{OBUID: 1, Lat: 0, Timestamp: t0})
+	est, _ := e.Estimate(1, t0.Add(time.Minute))
+	if est.Speed != 0 {
+		t.Errorf("speed %.2f m/s from an out-of-order reading, want 0", est.Speed)
+	}
+}
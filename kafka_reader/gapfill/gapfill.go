@@ -0,0 +1,71 @@
+package gapfill
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+// Filler watches each OBU's readings and, when two consecutive readings are
+// further apart than threshold, inserts synthetic points every spacing
+// between them so stored trails stay evenly spaced.
+type Filler struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	spacing   time.Duration
+	write     func(t types.SourceCoords, synthetic bool)
+	last      map[int]types.SourceCoords
+}
+
+// NewFiller returns a Filler that passes real and synthetic points to write,
+// in time order. threshold and spacing must be positive.
+func NewFiller(threshold, spacing time.Duration, write func(types.SourceCoords, bool)) (*Filler, error) {
+	if threshold <= 0 {
+		return nil, fmt.Errorf("gap threshold %v is not positive", threshold)
+	}
+	if spacing <= 0 {
+		return nil, fmt.Errorf("gap spacing %v is not positive", spacing)
+	}
+	return &Filler{
+		threshold: threshold,
+		spacing:   spacing,
+		write:     write,
+		last:      make(map[int]types.SourceCoords),
+	}, nil
+}
+
+// Observe writes any synthetic points needed before t, then t itself.
+// Readings without a timestamp, or older than the previous one, are passed
+// through untouched.
+func (f *Filler) Observe(t types.SourceCoords) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prev, ok := f.last[t.OBUID]
+	if t.Timestamp.IsZero() || (ok && t.Timestamp.Before(prev.Timestamp)) {
+		f.write(t, false)
+		return
+	}
+	if ok && t.Timestamp.Sub(prev.Timestamp) > f.threshold {
+		f.interpolate(prev, t)
+	}
+	f.last[t.OBUID] = t
+	f.write(t, false)
+}
+
+// interpolate writes points on the straight line between a and b. Over the
+// short gaps this is meant for, the error against the great-circle path is
+// negligible.
+func (f *Filler) interpolate(a, b types.SourceCoords) {
+	total := b.Timestamp.Sub(a.Timestamp)
+	for at := a.Timestamp.Add(f.spacing); at.Before(b.Timestamp); at = at.Add(f.spacing) {
+		frac := float64(at.Sub(a.Timestamp)) / float64(total)
+		f.write(types.SourceCoords{
+			OBUID:     a.OBUID,
+			Lat:       a.Lat + (b.Lat-a.Lat)*frac,
+			Lon:       a.Lon + (b.Lon-a.Lon)*frac,
+			Timestamp: at,
+		}, true)
+	}
+}
@@ -0,0 +1,141 @@
+package geofence
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/erastusk/gpscords/geo"
+)
+
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// Fence is either a circular region, given by Center and Radius, or a
+// rectangular one, given by Box.
+type Fence struct {
+	ID     string  `json:"id"`
+	Center Point   `json:"center"`
+	Radius float64 `json:"radius,omitempty"` // meters
+	Box    *Box    `json:"box,omitempty"`
+}
+
+// Box is a latitude/longitude bounding box. A box with MinLon greater than
+// MaxLon spans the antimeridian.
+type Box struct {
+	MinLat float64 `json:"min_lat"`
+	MinLon float64 `json:"min_lon"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLon float64 `json:"max_lon"`
+}
+
+// Contains reports whether the point lies within f. Points exactly on the
+// boundary are inside.
+func (f Fence) Contains(lat, lon float64) bool {
+	if f.Box == nil {
+		return geo.Haversine(lat, lon, f.Center.Lat, f.Center.Lon) <= f.Radius
+	}
+	b := f.Box
+	if lat < b.MinLat || lat > b.MaxLat {
+		return false
+	}
+	if b.MinLon <= b.MaxLon {
+		return lon >= b.MinLon && lon <= b.MaxLon
+	}
+	return lon >= b.MinLon || lon <= b.MaxLon
+}
+
+// Load reads a JSON array of fences from path.
+func Load(path string) ([]Fence, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fences []Fence
+	if err := json.Unmarshal(b, &fences); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	seen := make(map[string]bool)
+	for _, f := range fences {
+		if f.ID == "" || seen[f.ID] {
+			return nil, fmt.Errorf("%s: fence ids must be unique and non-empty, got %q", path, f.ID)
+		}
+		switch {
+		case f.Box != nil && f.Radius != 0:
+			return nil, fmt.Errorf("%s: fence %q has both a radius and a box", path, f.ID)
+		case f.Box != nil && f.Box.MinLat > f.Box.MaxLat:
+			return nil, fmt.Errorf("%s: fence %q has min_lat above max_lat", path, f.ID)
+		case f.Box == nil && f.Radius <= 0:
+			return nil, fmt.Errorf("%s: fence %q needs a positive radius or a box", path, f.ID)
+		}
+		seen[f.ID] = true
+	}
+	return fences, nil
+}
+
+// Set holds the active fences and can reload them from their file.
+type Set struct {
+	path   string
+	mu     sync.RWMutex
+	fences []Fence
+}
+
+// NewSet loads the fences in path.
+func NewSet(path string) (*Set, error) {
+	s := &Set{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload replaces the active fences with the file's current contents. On
+// error the previous fences stay active.
+func (s *Set) Reload() error {
+	fences, err := Load(s.path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.fences = fences
+	s.mu.Unlock()
+	slog.Info("Loaded geofences", slog.Int("count", len(fences)), slog.String("path", s.path))
+	return nil
+}
+
+// ReloadOnSIGHUP reloads the fences whenever the process receives SIGHUP.
+func (s *Set) ReloadOnSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := s.Reload(); err != nil {
+				slog.Error("Keeping previous geofences", slog.Any("err", err))
+			}
+		}
+	}()
+}
+
+// Fences returns a copy of the active fences.
+func (s *Set) Fences() []Fence {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Fence(nil), s.fences...)
+}
+
+// ServeHTTP serves GET /geofences.
+func (s *Set) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Fences())
+}
@@ -0,0 +1,79 @@
+package geofence
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+// writeFences writes a fence file and returns its path.
+func writeFences(t *testing.T, json string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fences.json")
+	if err := os.WriteFile(path, []byte(json), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTrackerCrossings(t *testing.T) {
+	tests := []struct {
+		name   string
+		fences string
+		// The OBU walks east along the equator from start, 100 steps of
+		// 0.001° longitude.
+		start float64
+	}{
+		{"circle", `[{"id":"depot","center":{"lat":0,"lon":0},"radius":1000}]`, -0.05},
+		{"box", `[{"id":"depot","box":{"min_lat":-1,"min_lon":-0.01,"max_lat":1,"max_lon":0.01}}]`, -0.05},
+		{"box across the antimeridian", `[{"id":"dateline","box":{"min_lat":-1,"min_lon":179.99,"max_lat":1,"max_lon":-179.99}}]`, 179.95},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := NewSet(writeFences(t, tt.fences))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var events []Event
+			tr := NewTracker(set, func(e Event) { events = append(events, e) })
+			for i := 0; i <= 100; i++ {
+				lon := tt.start + float64(i)*0.001
+				if lon > 180 {
+					lon -= 360
+				}
+				tr.Observe(types.SourceCoords{OBUID: 7, Lat: 0, Lon: lon})
+			}
+			if len(events) != 2 || events[0].Type != EventEnter || events[1].Type != EventExit {
+				t.Fatalf("got events %+v, want one enter then one exit", events)
+			}
+			for _, e := range events {
+				if e.OBUID != 7 {
+					t.Errorf("%s event for OBU %d, want 7", e.Type, e.OBUID)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadRejectsBadFences(t *testing.T) {
+	tests := []struct {
+		name   string
+		fences string
+	}{
+		{"no id", `[{"center":{"lat":0,"lon":0},"radius":10}]`},
+		{"duplicate id", `[{"id":"a","center":{"lat":0,"lon":0},"radius":10},{"id":"a","center":{"lat":1,"lon":1},"radius":10}]`},
+		{"radius and box", `[{"id":"a","radius":10,"box":{"min_lat":0,"min_lon":0,"max_lat":1,"max_lon":1}}]`},
+		{"inverted box", `[{"id":"a","box":{"min_lat":1,"min_lon":0,"max_lat":0,"max_lon":1}}]`},
+		{"no radius", `[{"id":"a","center":{"lat":0,"lon":0}}]`},
+		{"not an array", `{"id":"a"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Load(writeFences(t, tt.fences)); err == nil {
+				t.Errorf("Load accepted %s", tt.fences)
+			}
+		})
+	}
+}
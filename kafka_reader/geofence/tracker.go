@@ -0,0 +1,75 @@
+package geofence
+
+import (
+	"sync"
+	"time"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+// Event types.
+const (
+	EventEnter = "enter"
+	EventExit  = "exit"
+)
+
+// Event reports an OBU crossing a fence boundary.
+type Event struct {
+	Type      string    `json:"type"`
+	FenceID   string    `json:"fence_id"`
+	OBUID     int       `json:"obuid"`
+	Lat       float64   `json:"lat"`
+	Lon       float64   `json:"lon"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type membership struct {
+	obuid int
+	fence string
+}
+
+// Tracker follows which fences each OBU is inside and reports transitions.
+// An OBU not seen before counts as outside every fence, so its first reading
+// inside one is an enter.
+type Tracker struct {
+	set    *Set
+	emit   func(Event)
+	mu     sync.Mutex
+	inside map[membership]bool
+}
+
+// NewTracker returns a Tracker over the fences in set that calls emit for
+// every enter and exit. Reloading set takes effect from the next reading.
+func NewTracker(set *Set, emit func(Event)) *Tracker {
+	return &Tracker{set: set, emit: emit, inside: make(map[membership]bool)}
+}
+
+// Observe checks t against every fence.
+func (tr *Tracker) Observe(t types.SourceCoords) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	for _, f := range tr.set.Fences() {
+		m := membership{t.OBUID, f.ID}
+		in := f.Contains(t.Lat, t.Lon)
+		if in == tr.inside[m] {
+			continue
+		}
+		if in {
+			tr.inside[m] = true
+		} else {
+			delete(tr.inside, m)
+		}
+		typ := EventExit
+		if in {
+			typ = EventEnter
+		}
+		tr.emit(Event{
+			Type:      typ,
+			FenceID:   f.ID,
+			OBUID:     t.OBUID,
+			Lat:       t.Lat,
+			Lon:       t.Lon,
+			Timestamp: t.Timestamp,
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/erastusk/gpscords/geo"
+	"github.com/erastusk/gpscords/types"
+)
+
+const maxAttempts = 5
+
+type point struct {
+	types.SourceCoords
+	synthetic bool
+}
+
+// Sink batches readings as InfluxDB line protocol points and writes them to
+// an Influx write endpoint from its own goroutine, so a slow or unavailable
+// database never stalls the consumer.
+type Sink struct {
+	url       string
+	token     string
+	batchSize int
+	interval  time.Duration
+	client    *http.Client
+
+	points chan point
+	last   map[int]types.SourceCoords
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewSink starts a sink writing to url, for example
+// http://influx:8086/api/v2/write?org=fleet&bucket=gps&precision=ns. A batch
+// is written once it holds batchSize points or interval has passed.
+func NewSink(url, token string, batchSize int, interval time.Duration) (*Sink, error) {
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("batch size %d is not positive", batchSize)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("flush interval %v is not positive", interval)
+	}
+	s := &Sink{
+		url:       url,
+		token:     token,
+		batchSize: batchSize,
+		interval:  interval,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		points:    make(chan point, batchSize*4),
+		last:      make(map[int]types.SourceCoords),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Observe queues t for writing. When the queue is full t is dropped.
+func (s *Sink) Observe(t types.SourceCoords) {
+	s.Write(t, false)
+}
+
+// Write queues t, tagging it synthetic=true when it was interpolated rather
+// than reported by the OBU.
+func (s *Sink) Write(t types.SourceCoords, synthetic bool) {
+	select {
+	case s.points <- point{t, synthetic}:
+	default:
+		slog.Warn("Influx queue full, dropping reading", slog.Int("obuid", t.OBUID))
+	}
+}
+
+// Close writes whatever is still queued and stops the sink.
+func (s *Sink) Close() {
+	s.once.Do(func() {
+		close(s.points)
+		<-s.done
+	})
+}
+
+func (s *Sink) run() {
+	defer close(s.done)
+	var buf bytes.Buffer
+	n := 0
+	tick := time.NewTicker(s.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case p, ok := <-s.points:
+			if !ok {
+				s.flush(&buf, n)
+				return
+			}
+			s.line(&buf, p)
+			n++
+			if n >= s.batchSize {
+				s.flush(&buf, n)
+				n = 0
+			}
+		case <-tick.C:
+			s.flush(&buf, n)
+			n = 0
+		}
+	}
+}
+
+// line appends t to buf. Speed is only written when the previous reading
+// for the OBU makes it computable.
+func (s *Sink) line(buf *bytes.Buffer, p point) {
+	t := p.SourceCoords
+	ts := t.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	fmt.Fprintf(buf, "position,obuid=%d", t.OBUID)
+	if p.synthetic {
+		buf.WriteString(",synthetic=true")
+	}
+	fmt.Fprintf(buf, " lat=%s,lon=%s",
+		strconv.FormatFloat(t.Lat, 'f', -1, 64), strconv.FormatFloat(t.Lon, 'f', -1, 64))
+	if prev, ok := s.last[t.OBUID]; ok && !prev.Timestamp.IsZero() && !t.Timestamp.IsZero() {
+		if dt := t.Timestamp.Sub(prev.Timestamp).Seconds(); dt > 0 {
+			speed := geo.Haversine(prev.Lat, prev.Lon, t.Lat, t.Lon) / dt
+			fmt.Fprintf(buf, ",speed=%s", strconv.FormatFloat(speed, 'f', -1, 64))
+		}
+	}
+	fmt.Fprintf(buf, " %d\n", ts.UnixNano())
+	s.last[t.OBUID] = t
+}
+
+func (s *Sink) flush(buf *bytes.Buffer, n int) {
+	if n == 0 {
+		return
+	}
+	defer buf.Reset()
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := s.write(buf.Bytes())
+		if err == nil {
+			return
+		}
+		if attempt == maxAttempts {
+			slog.Error("Dropping points after failed Influx writes", slog.Int("points", n), slog.Int("attempts", attempt), slog.Any("err", err))
+			return
+		}
+		slog.Warn("Influx write failed, retrying", slog.Int("attempt", attempt), slog.Any("err", err))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *Sink) write(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("influx write: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package kafka
+
+import (
+	"log/slog"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"github.com/erastusk/gpscords/stats"
+)
+
+// HeaderError names the header a dead-lettered record carries the reason it
+// couldn't be processed in.
+const HeaderError = "error"
+
+// deadLetters republishes records the consumer can't process to a separate
+// topic, so they can be inspected and replayed instead of being lost.
+type deadLetters struct {
+	p     *kafka.Producer
+	topic string
+}
+
+func newDeadLetters(cm kafka.ConfigMap, topic string) (*deadLetters, error) {
+	p, err := kafka.NewProducer(&cm)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for e := range p.Events() {
+			if m, ok := e.(*kafka.Message); ok && m.TopicPartition.Error != nil {
+				slog.Error("Couldn't dead-letter record", slog.Int64("offset", int64(m.TopicPartition.Offset)), slog.Any("err", m.TopicPartition.Error))
+				stats.Errors.Add(1)
+			}
+		}
+	}()
+	return &deadLetters{p: p, topic: topic}, nil
+}
+
+// publish sends msg unchanged to the dead-letter topic, adding an error
+// header with reason. It reports whether msg was queued for the topic.
+func (d *deadLetters) publish(msg *kafka.Message, reason error) bool {
+	headers := append(msg.Headers[:len(msg.Headers):len(msg.Headers)],
+		kafka.Header{Key: HeaderError, Value: []byte(reason.Error())})
+	err := d.p.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &d.topic, Partition: kafka.PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        headers,
+	}, nil)
+	if err != nil {
+		slog.Error("Couldn't dead-letter record", slog.Int64("offset", int64(msg.TopicPartition.Offset)), slog.Any("err", err))
+		stats.Errors.Add(1)
+		return false
+	}
+	deadLettered.Inc()
+	return true
+}
+
+// close waits for outstanding dead letters to be delivered.
+func (d *deadLetters) close() {
+	if n := d.p.Flush(15 * 1000); n > 0 {
+		slog.Warn("Closing dead-letter producer with records undelivered", slog.Int("undelivered", n))
+	}
+	d.p.Close()
+}
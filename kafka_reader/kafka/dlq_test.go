@@ -0,0 +1,56 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+func TestUndecodableRecordIsDeadLettered(t *testing.T) {
+	brokers := startCluster(t, `{"obuid":1,"lat":1,"lon":1}`, `not json`, `{"obuid":2,"lat":2,"lon":2}`)
+	cfg := testConfig(t, brokers)
+	cfg.DeadLetterTopic = "gps-test-dlq"
+	c, err := NewKafkaConsumer(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings := make(chan types.SourceCoords, 10)
+	sink := sinkFunc(func(_ context.Context, t types.SourceCoords) error {
+		readings <- t
+		return nil
+	})
+	// The consumer flushes the dead letters when it stops.
+	consume(t, c, sink, func() bool { return len(readings) == 2 })
+	close(readings)
+	for r := range readings {
+		if r.OBUID != 1 && r.OBUID != 2 {
+			t.Errorf("sink got %+v, want only OBUs 1 and 2", r)
+		}
+	}
+
+	dlq, err := kafka.NewConsumer(&kafka.ConfigMap{"bootstrap.servers": brokers, "group.id": "dlq-reader", "auto.offset.reset": "earliest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dlq.Close()
+	if err := dlq.Subscribe(cfg.DeadLetterTopic, nil); err != nil {
+		t.Fatal(err)
+	}
+	m, err := dlq.ReadMessage(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Value) != "not json" {
+		t.Errorf("dead letter %q, want %q", m.Value, "not json")
+	}
+	if h := header(m.Headers, HeaderError); h == "" {
+		t.Errorf("dead letter has no %s header", HeaderError)
+	}
+	if m, err := dlq.ReadMessage(time.Second); err == nil {
+		t.Errorf("unexpected second dead letter %q", m.Value)
+	}
+}
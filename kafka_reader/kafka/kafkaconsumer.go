@@ -1,94 +1,738 @@
 package kafka
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 
+	"github.com/erastusk/gpscords/config"
+	"github.com/erastusk/gpscords/stats"
 	"github.com/erastusk/gpscords/types"
 )
 
+// Connection defaults, used for settings left empty in Config.
+const (
+	DefaultBrokers     = "gpscords_app-kafka-1:9092"
+	DefaultTopic       = "gpscoords"
+	DefaultGroupID     = "gps"
+	DefaultOffsetReset = "earliest"
+)
+
+// Errors wrapped by the consumer's failures, for use with errors.Is.
 var (
-	server       = "gpscords_app-kafka-1:9092"
-	topic        = "gpscoords"
-	offset_reset = "earliest"
-	group_id     = "gps"
+	// ErrConsumerInit is wrapped by NewKafkaConsumer when the clients can't
+	// be set up or a required topic is missing.
+	ErrConsumerInit = errors.New("kafka consumer init failed")
+	// ErrSubscribe is wrapped by KafkaConsume when subscribing fails.
+	ErrSubscribe = errors.New("kafka subscribe failed")
+	// ErrConsume is wrapped by KafkaConsume when the consumer fails while
+	// polling.
+	ErrConsume = errors.New("kafka consume failed")
+	// ErrSink is wrapped by KafkaConsume when the sink fails to write a
+	// reading whose record is neither committed yet nor dead-lettered.
+	ErrSink = errors.New("sink write failed")
 )
 
+// Partition assignment strategies accepted by Config.AssignmentStrategy.
+var assignmentStrategies = map[string]bool{
+	"range":              true,
+	"roundrobin":         true,
+	"cooperative-sticky": true,
+}
+
+// Values accepted by Config.OffsetReset.
+var offsetResets = map[string]bool{
+	"earliest": true,
+	"latest":   true,
+}
+
+// Config holds the tunables for a KafkaConsumer. ConfigFlags binds the
+// connection settings to flags.
+type Config struct {
+	// Brokers is the bootstrap.servers list.
+	Brokers string
+	// Topics are the topics subscribed to.
+	Topics []string
+	// TagTopic sets the Topic of every reading to the topic it was consumed
+	// from, so handlers can tell feeds apart.
+	TagTopic bool
+	// GroupID is the consumer group.
+	GroupID string
+	// OffsetReset is auto.offset.reset, where to start when the group has
+	// no committed offset: "earliest" replays the whole topic, "latest"
+	// only reads records produced from now on.
+	OffsetReset string
+	// Security configures SASL and TLS. The zero value connects in
+	// plaintext.
+	Security config.KafkaSecurity
+	// AssignmentStrategy selects the group partition assignment strategy.
+	// Empty keeps the librdkafka default.
+	AssignmentStrategy string
+	// SampleRate is the fraction of messages, in (0, 1], that are processed.
+	// Zero is treated as 1, processing everything.
+	SampleRate float64
+	// HeartbeatOBUID is the sentinel OBUID of heartbeat readings. Heartbeats
+	// are logged and counted but never handed to the application. Zero
+	// disables heartbeats, so no reading is filtered out.
+	HeartbeatOBUID int
+	// RequireTopic makes NewKafkaConsumer fail when a topic doesn't exist
+	// yet, instead of waiting for it to be created.
+	RequireTopic bool
+	// MaxMessages stops the consumer after this many messages have been
+	// handed to the application, committing the record of the last one even
+	// when that is part way through a batch. Zero means no limit.
+	MaxMessages int
+	// SkewThreshold enables the clock skew diagnostic and warns about OBUs
+	// whose reading timestamps differ from the record timestamp by more than
+	// this. Zero disables it.
+	SkewThreshold time.Duration
+	// DeadLetterTopic receives messages that can't be decoded, with their
+	// original key, value and headers plus an error header. Empty drops them
+	// after logging.
+	DeadLetterTopic string
+	// DeadLetterSinkErrors also dead-letters records whose reading the Sink
+	// failed to write, and commits them so consuming carries on. It needs
+	// DeadLetterTopic.
+	DeadLetterSinkErrors bool
+	// Codec decodes message values. Nil means types.JSONCodec.
+	Codec types.Codec
+	// Buffer is how many readings may wait for the handlers, so polling
+	// isn't held up by a slow handler. Zero means DefaultBuffer; negative is
+	// an error.
+	Buffer int
+	// DropAfter makes the consumer drop a reading, rather than stop
+	// polling, when the buffer stays full this long. Dropped readings are
+	// counted but never redelivered, so this gives up at-least-once
+	// delivery.
+	// Zero waits for room indefinitely.
+	DropAfter time.Duration
+	// Delivery chooses when offsets are committed relative to processing.
+	// Empty means AtLeastOnce.
+	Delivery DeliveryMode
+	// PollTimeout is how long each poll waits for a record. Shorter makes
+	// cancellation take effect sooner at the cost of more wakeups while the
+	// topic is idle; records are returned as soon as they arrive either way.
+	// Zero means DefaultPollTimeout; negative is an error.
+	PollTimeout time.Duration
+	// LagInterval is how often the kafka_consumer_lag gauge is refreshed
+	// while consuming. Zero disables it.
+	LagInterval time.Duration
+}
+
+// DeliveryMode is the guarantee a consumer gives about readings across
+// crashes and restarts. Offsets are committed manually in either mode.
+type DeliveryMode string
+
+const (
+	// AtLeastOnce commits a record only after the handlers and sink have
+	// processed it. A crash in between means the reading is consumed again,
+	// so none are lost but some may be seen twice. It suits persistence.
+	AtLeastOnce DeliveryMode = "at-least-once"
+	// AtMostOnce commits a record before handing its reading over. A crash
+	// in between loses the reading, but none is ever processed twice, and
+	// a restart resumes with the freshest data. It suits live views. A
+	// record whose commit fails is dropped rather than processed.
+	AtMostOnce DeliveryMode = "at-most-once"
+)
+
+// DefaultBuffer is the default Config.Buffer.
+const DefaultBuffer = 1000
+
+// DefaultPollTimeout is the default Config.PollTimeout.
+const DefaultPollTimeout = 100 * time.Millisecond
+
+// delivery is a reading on its way to the handlers, with the record to
+// commit once they're done unless it was committed already. msg is nil for
+// injected readings.
+type delivery struct {
+	t         types.SourceCoords
+	msg       *kafka.Message
+	committed bool
+}
+
+// ConfigFlags defines the connection flags on fs: -kafka-brokers,
+// -kafka-topics, -kafka-group-id, -offset-reset and those of
+// config.KafkaSecurityFlags. They fill the returned Config when fs is
+// loaded, so they resolve like any other setting. Each defaults to the
+// KAFKA_* variable earlier releases read, e.g. KAFKA_GROUP_ID, else to the
+// package default; -kafka-topics falls back to KAFKA_TOPICS and then the
+// older single KAFKA_TOPIC.
+func ConfigFlags(fs *flag.FlagSet) *Config {
+	cfg := &Config{Topics: splitTopics(getenv("KAFKA_TOPICS", getenv("KAFKA_TOPIC", DefaultTopic)))}
+	fs.StringVar(&cfg.Brokers, "kafka-brokers", getenv("KAFKA_BROKERS", DefaultBrokers), "comma-separated Kafka bootstrap servers")
+	fs.Var(topicList{&cfg.Topics}, "kafka-topics", "comma-separated topics to consume")
+	fs.StringVar(&cfg.GroupID, "kafka-group-id", getenv("KAFKA_GROUP_ID", DefaultGroupID), "consumer group")
+	fs.StringVar(&cfg.OffsetReset, "offset-reset", getenv("KAFKA_OFFSET_RESET", DefaultOffsetReset), "where a group without committed offsets starts: earliest or latest")
+	config.KafkaSecurityFlags(fs, &cfg.Security)
+	return cfg
+}
+
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// topicList is a flag.Value setting a topic list from its comma-separated
+// form.
+type topicList struct{ topics *[]string }
+
+func (l topicList) String() string {
+	if l.topics == nil {
+		return ""
+	}
+	return strings.Join(*l.topics, ",")
+}
+
+func (l topicList) Set(s string) error {
+	*l.topics = splitTopics(s)
+	return nil
+}
+
+// splitTopics parses a comma-separated topic list, ignoring blanks.
+func splitTopics(s string) []string {
+	var topics []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			topics = append(topics, t)
+		}
+	}
+	return topics
+}
+
+// withDefaults fills the empty connection settings of cfg.
+func (cfg Config) withDefaults() Config {
+	if cfg.Brokers == "" {
+		cfg.Brokers = DefaultBrokers
+	}
+	if len(cfg.Topics) == 0 {
+		cfg.Topics = []string{DefaultTopic}
+	}
+	if cfg.GroupID == "" {
+		cfg.GroupID = DefaultGroupID
+	}
+	if cfg.OffsetReset == "" {
+		cfg.OffsetReset = DefaultOffsetReset
+	}
+	return cfg
+}
+
+// configMap builds the librdkafka configuration for cfg. Offsets are only
+// committed by CommitMessage, never in the background; see there.
+func configMap(cfg Config) kafka.ConfigMap {
+	cm := clientConfigMap(cfg)
+	cm["auto.offset.reset"] = cfg.OffsetReset
+	cm["group.id"] = cfg.GroupID
+	cm["enable.auto.commit"] = false
+	cm["enable.auto.offset.store"] = false
+	// Rebalances arrive as events from Poll; see rebalance.
+	cm["go.application.rebalance.enable"] = true
+	return cm
+}
+
+// clientConfigMap holds the settings any client of the cluster needs, the
+// dead-letter producer as well as the consumer.
+func clientConfigMap(cfg Config) kafka.ConfigMap {
+	cm := kafka.ConfigMap{"bootstrap.servers": cfg.Brokers}
+	for k, v := range cfg.Security.Properties() {
+		cm[k] = v
+	}
+	return cm
+}
+
 type KafkaConsumer struct {
 	Consumer *kafka.Consumer
-	topic    string
-	msgChan  chan types.SourceCoords
+	topics   []string
+	tagTopic bool
+	msgChan  chan delivery
+	dropWait time.Duration
+	sample   float64
+	beatID   int
+	max      int
+	skew     *skewMonitor
+	dlq      *deadLetters
+	dlqSink  bool
+	codec    types.Codec
+	lagEvery time.Duration
+	pollWait time.Duration
+	mode     DeliveryMode
+	handlers []func(types.SourceCoords)
+
+	// err is why polling stopped, if it failed. It is set before msgChan
+	// is closed.
+	err error
+
+	// mu guards closing msgChan against concurrent Inject calls.
+	mu     sync.RWMutex
+	closed bool
 }
 
-func NewKafkaConsumer() (*KafkaConsumer, error) {
-	c, err := kafka.NewConsumer(&kafka.ConfigMap{
-		"bootstrap.servers": server,
-		"auto.offset.reset": offset_reset,
-		"group.id":          group_id,
-	})
+func NewKafkaConsumer(ctx context.Context, cfg Config) (*KafkaConsumer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cfg = cfg.withDefaults()
+	if !offsetResets[cfg.OffsetReset] {
+		return nil, fmt.Errorf("unknown offset reset %q, want earliest or latest", cfg.OffsetReset)
+	}
+	cm := configMap(cfg)
+	if cfg.AssignmentStrategy != "" {
+		if !assignmentStrategies[cfg.AssignmentStrategy] {
+			return nil, fmt.Errorf("unknown partition assignment strategy %q", cfg.AssignmentStrategy)
+		}
+		cm["partition.assignment.strategy"] = cfg.AssignmentStrategy
+	}
+	if cfg.SampleRate == 0 {
+		cfg.SampleRate = 1
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return nil, fmt.Errorf("sample rate %v outside (0, 1]", cfg.SampleRate)
+	}
+	if cfg.PollTimeout < 0 {
+		// librdkafka would take a negative timeout as wait forever.
+		return nil, fmt.Errorf("poll timeout %v is negative", cfg.PollTimeout)
+	}
+	if cfg.Buffer < 0 {
+		return nil, fmt.Errorf("buffer %d is negative", cfg.Buffer)
+	}
+	var skew *skewMonitor
+	if cfg.SkewThreshold > 0 {
+		skew = newSkewMonitor(cfg.SkewThreshold)
+	}
+	c, err := kafka.NewConsumer(&cm)
 	if err != nil {
-		log.Fatal("Couldn't create a consumer", err)
+		return nil, fmt.Errorf("%w: %w", ErrConsumerInit, err)
+	}
+	if cfg.RequireTopic {
+		for _, topic := range cfg.Topics {
+			if err := checkTopic(c, topic); err != nil {
+				c.Close()
+				return nil, fmt.Errorf("%w: %w", ErrConsumerInit, err)
+			}
+		}
+	}
+	if cfg.Buffer == 0 {
+		cfg.Buffer = DefaultBuffer
+	}
+	switch cfg.Delivery {
+	case "":
+		cfg.Delivery = AtLeastOnce
+	case AtLeastOnce, AtMostOnce:
+	default:
+		c.Close()
+		return nil, fmt.Errorf("unknown delivery mode %q", cfg.Delivery)
+	}
+	if cfg.PollTimeout == 0 {
+		cfg.PollTimeout = DefaultPollTimeout
+	}
+	codec := cfg.Codec
+	if codec == nil {
+		codec = types.JSONCodec{}
+	}
+	var dlq *deadLetters
+	if cfg.DeadLetterTopic != "" {
+		if dlq, err = newDeadLetters(clientConfigMap(cfg), cfg.DeadLetterTopic); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("%w: dead-letter producer: %w", ErrConsumerInit, err)
+		}
 	}
 	return &KafkaConsumer{
 		Consumer: c,
-		topic:    topic,
-		msgChan:  make(chan types.SourceCoords),
+		topics:   cfg.Topics,
+		tagTopic: cfg.TagTopic,
+		msgChan:  make(chan delivery, cfg.Buffer),
+		dropWait: cfg.DropAfter,
+		sample:   cfg.SampleRate,
+		beatID:   cfg.HeartbeatOBUID,
+		max:      cfg.MaxMessages,
+		skew:     skew,
+		dlq:      dlq,
+		dlqSink:  cfg.DeadLetterSinkErrors,
+		codec:    codec,
+		lagEvery: cfg.LagInterval,
+		pollWait: cfg.PollTimeout,
+		mode:     cfg.Delivery,
 	}, nil
 }
 
-func (c *KafkaConsumer) KafkaConsume() error {
-	err := c.Consumer.SubscribeTopics([]string{topic}, nil)
+// OnMessage registers f to be called with every consumed reading. It must be
+// called before KafkaConsume.
+func (c *KafkaConsumer) OnMessage(f func(types.SourceCoords)) {
+	c.handlers = append(c.handlers, f)
+}
+
+// checkTopic looks topic up in the cluster metadata. All topics are listed
+// rather than asking for topic by name, which would auto-create it on brokers
+// that allow that.
+func checkTopic(c *kafka.Consumer, topic string) error {
+	md, err := c.GetMetadata(nil, true, 10*1000)
 	if err != nil {
-		log.Fatal("subscribe topics failed", err)
-		return err
+		return fmt.Errorf("fetching metadata for topic %q: %w", topic, err)
 	}
-	go kafkaconsumeLoop(c)
-	for a := range c.msgChan {
-		fmt.Printf("Kafka consumer : %+v\n", a)
+	t, ok := md.Topics[topic]
+	if !ok || t.Error.Code() == kafka.ErrUnknownTopicOrPart {
+		return fmt.Errorf("topic %q does not exist", topic)
+	}
+	if t.Error.Code() != kafka.ErrNoError {
+		return fmt.Errorf("topic %q: %w", topic, t.Error)
 	}
 	return nil
 }
 
-//	func kafkaconsumeLoop(c *KafkaConsumer) {
-//		t := types.SourceCoords{}
-//		defer c.Consumer.Close()
-//		for {
-//			msg, err := c.Consumer.ReadMessage(-1)
-//			if err != nil {
-//				fmt.Println("Could not read messages", err)
-//				log.Fatal(err)
-//			}
-//			err = json.Unmarshal(msg.Value, &t)
+// KafkaConsume consumes until ctx is cancelled or the consumer fails, handing
+// every reading to the registered handlers and then to sink, and committing
+// it once they return, or once a BatchSink has stored it. A nil sink means
+// StdoutSink. On cancellation it stops polling, lets the handlers and sink
+// finish what is already buffered, commits, closes the consumer and returns.
 //
-//			if err != nil {
-//				log.Println("Couldn't unmarshal message", err)
-//			}
-//			c.msgChan <- t
-//		}
-//	}
-func kafkaconsumeLoop(c *KafkaConsumer) {
+// When sink fails a reading whose record isn't committed yet, and the record
+// isn't dead-lettered either, KafkaConsume stops without committing it or
+// anything after it, so all of them are consumed again on restart. Failures
+// wrap ErrSubscribe, ErrConsume or ErrSink.
+func (c *KafkaConsumer) KafkaConsume(ctx context.Context, sink Sink) error {
+	err := c.Consumer.SubscribeTopics(c.topics, nil)
+	if err != nil {
+		if c.dlq != nil {
+			c.dlq.close()
+		}
+		c.Consumer.Close()
+		return fmt.Errorf("%w: %w", ErrSubscribe, err)
+	}
+	if sink == nil {
+		sink = StdoutSink{}
+	}
 	defer c.Consumer.Close()
-	t := types.SourceCoords{}
+	defer commitPending(c.Consumer)
+	if c.dlq != nil {
+		defer c.dlq.close()
+	}
+	if c.lagEvery > 0 {
+		// The exporter must be done with the consumer before it's closed.
+		lagCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			c.exportLag(lagCtx, c.lagEvery)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+	}
+	pollCtx, stopPolling := context.WithCancel(ctx)
+	defer stopPolling()
+	go kafkaconsumeLoop(pollCtx, c)
+
+	// finish commits d once the sink is done with its reading. A BatchSink
+	// calls it from its own goroutine.
+	var (
+		mu      sync.Mutex
+		sinkErr error
+		pending sync.WaitGroup
+	)
+	finish := func(d delivery, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if sinkErr != nil {
+			// Left uncommitted, behind the record the sink failed.
+			return
+		}
+		if err != nil && !c.sinkFailed(d, err) && c.mode == AtLeastOnce && d.msg != nil {
+			offset := d.msg.TopicPartition.Offset
+			slog.Error("Stopping without committing the record", slog.Int64("offset", int64(offset)))
+			sinkErr = fmt.Errorf("%w: offset %d: %w", ErrSink, offset, err)
+			stopPolling()
+			return
+		}
+		if d.msg != nil && !d.committed {
+			if err := c.CommitMessage(d.msg); err != nil {
+				slog.Error("Couldn't commit offset", slog.Int64("offset", int64(d.msg.TopicPartition.Offset)), slog.Any("err", err))
+				stats.Errors.Add(1)
+			}
+		}
+	}
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return sinkErr != nil
+	}
+
+	batch, _ := sink.(BatchSink)
+	// Buffered readings still reach the sink after cancellation.
+	sinkCtx := context.WithoutCancel(ctx)
+	for d := range c.msgChan {
+		if failed() {
+			continue
+		}
+		a := d.t
+		slog.Info("Consumed reading", slog.Int("obuid", a.OBUID),
+			slog.Float64("lat", a.Lat), slog.Float64("lon", a.Lon), slog.Time("timestamp", a.Timestamp))
+		for _, f := range c.handlers {
+			f(a)
+		}
+		if batch == nil {
+			finish(d, sink.Write(sinkCtx, a))
+			continue
+		}
+		pending.Add(1)
+		batch.WriteAsync(sinkCtx, a, func(err error) {
+			defer pending.Done()
+			finish(d, err)
+		})
+	}
+	// The consumer must stay open until the last batch is committed.
+	pending.Wait()
+	if sinkErr != nil {
+		return sinkErr
+	}
+	return c.err
+}
+
+// rebalance logs a group rebalance and applies it. Eager protocols replace
+// the whole assignment; cooperative-sticky only moves the listed partitions.
+func (c *KafkaConsumer) rebalance(ev kafka.Event) {
+	cooperative := c.Consumer.GetRebalanceProtocol() == "COOPERATIVE"
+	var err error
+	switch e := ev.(type) {
+	case kafka.AssignedPartitions:
+		slog.Info("Partitions assigned", slog.Any("partitions", partitionNames(e.Partitions)))
+		if cooperative {
+			err = c.Consumer.IncrementalAssign(e.Partitions)
+		} else {
+			err = c.Consumer.Assign(e.Partitions)
+		}
+	case kafka.RevokedPartitions:
+		slog.Info("Partitions revoked", slog.Any("partitions", partitionNames(e.Partitions)))
+		if cooperative {
+			err = c.Consumer.IncrementalUnassign(e.Partitions)
+		} else {
+			err = c.Consumer.Unassign()
+		}
+	}
+	if err != nil {
+		slog.Error("Couldn't apply rebalance", slog.Any("err", err))
+		stats.Errors.Add(1)
+		consumeErrors.Inc()
+	}
+}
+
+// partitionNames formats partitions as topic/partition for logging.
+func partitionNames(partitions []kafka.TopicPartition) []string {
+	names := make([]string, len(partitions))
+	for i, tp := range partitions {
+		names[i] = fmt.Sprintf("%s/%d", *tp.Topic, tp.Partition)
+	}
+	return names
+}
+
+// decode returns the readings in e: every element of a batch record, or
+// else the single reading the codec decodes. Each record decodes into fresh
+// values, so nothing from an earlier record can leak into them.
+func (c *KafkaConsumer) decode(e *kafka.Message) ([]types.SourceCoords, error) {
+	if header(e.Headers, types.HeaderBatch) != "" {
+		var batch []types.SourceCoords
+		err := json.Unmarshal(e.Value, &batch)
+		return batch, err
+	}
+	t, err := c.codec.Decode(e.Value)
+	if err != nil {
+		return nil, err
+	}
+	return []types.SourceCoords{t}, nil
+}
+
+// sinkFailed reports a reading the sink couldn't write, dead-lettering its
+// record if configured. It reports whether the record was dead-lettered.
+func (c *KafkaConsumer) sinkFailed(d delivery, err error) bool {
+	slog.Error("Sink couldn't write reading", slog.Int("obuid", d.t.OBUID), slog.Any("err", err))
+	stats.Errors.Add(1)
+	sinkErrors.Inc()
+	return c.dlqSink && c.dlq != nil && d.msg != nil && c.dlq.publish(d.msg, err)
+}
+
+// Inject hands t to the application as if it had been consumed from Kafka.
+// It exists for debugging sinks and must not be exposed in production.
+func (c *KafkaConsumer) Inject(t types.SourceCoords) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return errors.New("consumer stopped")
+	}
+	c.msgChan <- delivery{t: t}
+	return nil
+}
+
+// CommitMessage synchronously commits msg's offset. Whether that happens
+// before or after the handlers process the reading depends on the
+// consumer's DeliveryMode.
+func (c *KafkaConsumer) CommitMessage(msg *kafka.Message) error {
+	_, err := c.Consumer.CommitMessage(msg)
+	return err
+}
+
+func (c *KafkaConsumer) closeMsgChan() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	close(c.msgChan)
+}
+
+// send queues d for the handlers, dropping it if the buffer stays full for
+// longer than c.dropWait. It reports whether d was queued.
+func (c *KafkaConsumer) send(d delivery) bool {
+	if c.dropWait == 0 {
+		c.msgChan <- d
+		return true
+	}
+	select {
+	case c.msgChan <- d:
+		return true
+	default:
+	}
+	timer := time.NewTimer(c.dropWait)
+	defer timer.Stop()
+	select {
+	case c.msgChan <- d:
+		return true
+	case <-timer.C:
+		messagesDropped.Inc()
+		return false
+	}
+}
+
+func kafkaconsumeLoop(ctx context.Context, c *KafkaConsumer) {
+	defer c.closeMsgChan()
+	consumed := 0
 	run := true
 	for run == true {
-		ev := c.Consumer.Poll(100)
+		select {
+		case <-ctx.Done():
+			slog.Info("Consumer stopping", slog.Any("reason", ctx.Err()))
+			return
+		default:
+		}
+		ev := c.Consumer.Poll(int(c.pollWait.Milliseconds()))
 		switch e := ev.(type) {
+		case nil:
+			// Poll timed out with nothing to report.
 		case *kafka.Message:
 			// application-specific processing
-			log.Printf("%v", e.Headers)
-			err := json.Unmarshal(e.Value, &t)
-			//
+			slog.Debug("Polled record", slog.Int("partition", int(e.TopicPartition.Partition)),
+				slog.Int64("offset", int64(e.TopicPartition.Offset)),
+				slog.String("obuid", header(e.Headers, types.HeaderOBUID)),
+				slog.String("producer_version", header(e.Headers, types.HeaderProducerVersion)))
+			if expired(e.Headers, time.Now()) {
+				slog.Info("Dropping expired record", slog.Int64("offset", int64(e.TopicPartition.Offset)))
+				continue
+			}
+			readings, err := c.decode(e)
 			if err != nil {
-				log.Println("Couldn't unmarshal message", err)
+				slog.Error("Couldn't decode record", slog.Int64("offset", int64(e.TopicPartition.Offset)), slog.Any("err", err))
+				stats.Errors.Add(1)
+				consumeErrors.Inc()
+				if c.dlq != nil {
+					c.dlq.publish(e, err)
+				}
+				continue
+			}
+			if c.mode == AtMostOnce {
+				if err := c.CommitMessage(e); err != nil {
+					slog.Error("Couldn't commit offset, dropping record", slog.Int64("offset", int64(e.TopicPartition.Offset)), slog.Any("err", err))
+					stats.Errors.Add(1)
+					continue
+				}
+			}
+			for i, t := range readings {
+				if c.tagTopic {
+					t.Topic = *e.TopicPartition.Topic
+				}
+				if c.beatID != 0 && t.OBUID == c.beatID {
+					slog.Info("Heartbeat received", slog.Time("sent_at", t.Timestamp))
+					heartbeatsConsumed.Inc()
+					continue
+				}
+				if c.skew != nil {
+					c.skew.observe(t.OBUID, t.Timestamp, e.Timestamp)
+				}
+				if !sampled(t.OBUID, int64(e.TopicPartition.Offset), c.sample) {
+					continue
+				}
+				// Only the last reading of a batch commits the record, so
+				// a crash part way through redelivers the whole batch. When
+				// the message limit cuts the batch short, the reading it
+				// stops at commits it instead.
+				last := i == len(readings)-1 || (c.max > 0 && consumed+1 >= c.max)
+				d := delivery{t: t, msg: e, committed: c.mode == AtMostOnce || !last}
+				if !c.send(d) {
+					slog.Warn("Handlers falling behind, dropping reading", slog.Int("obuid", t.OBUID))
+					continue
+				}
+				stats.Consumed.Add(1)
+				messagesConsumed.Inc()
+				consumed++
+				if c.max > 0 && consumed >= c.max {
+					slog.Info("Reached message limit, stopping", slog.Int("consumed", consumed))
+					run = false
+					break
+				}
 			}
-			c.msgChan <- t
+		case kafka.AssignedPartitions, kafka.RevokedPartitions:
+			c.rebalance(e)
 		case kafka.Error:
-			fmt.Fprintf(os.Stderr, "%% Error: %v\n", e)
+			slog.Error("Consumer failed", slog.Any("err", e))
+			stats.Errors.Add(1)
+			consumeErrors.Inc()
+			c.err = fmt.Errorf("%w: %w", ErrConsume, e)
 			run = false
 		}
 	}
 }
+
+// header returns the value of the first header named key, or "" if there is
+// none.
+func header(headers []kafka.Header, key string) string {
+	for _, h := range headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// expired reports whether the record carries an expires-at header that lies
+// before now. Records without the header, or with one that can't be parsed,
+// never expire.
+func expired(headers []kafka.Header, now time.Time) bool {
+	for _, h := range headers {
+		if h.Key != types.HeaderExpiresAt {
+			continue
+		}
+		at, err := types.ParseExpiresAt(h.Value)
+		if err != nil {
+			slog.Warn("Ignoring malformed expires-at header", slog.Any("err", err))
+			return false
+		}
+		return now.After(at)
+	}
+	return false
+}
+
+// commitPending synchronously commits any offsets still stored but not yet
+// committed.
+func commitPending(c *kafka.Consumer) {
+	_, err := c.Commit()
+	var kerr kafka.Error
+	if err != nil && !(errors.As(err, &kerr) && kerr.Code() == kafka.ErrNoOffset) {
+		slog.Error("Couldn't commit offsets on shutdown", slog.Any("err", err))
+	}
+}
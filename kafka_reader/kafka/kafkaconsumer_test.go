@@ -0,0 +1,711 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"github.com/erastusk/gpscords/config"
+	"github.com/erastusk/gpscords/stats"
+	"github.com/erastusk/gpscords/types"
+)
+
+// configEnv lists the legacy variables ConfigFlags takes its defaults from.
+var configEnv = []string{
+	"KAFKA_BROKERS", "KAFKA_TOPICS", "KAFKA_TOPIC", "KAFKA_GROUP_ID", "KAFKA_OFFSET_RESET",
+	"KAFKA_SECURITY_PROTOCOL", "KAFKA_SASL_MECHANISM", "KAFKA_SASL_USERNAME", "KAFKA_SASL_PASSWORD",
+	"KAFKA_SSL_CA_LOCATION", "KAFKA_SSL_CERT_LOCATION", "KAFKA_SSL_KEY_LOCATION",
+}
+
+func TestConfigFlags(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		args   []string
+		topics []string
+		want   kafka.ConfigMap
+	}{
+		{
+			name:   "defaults",
+			topics: []string{DefaultTopic},
+			want: kafka.ConfigMap{
+				"bootstrap.servers": DefaultBrokers,
+				"group.id":          DefaultGroupID,
+				"auto.offset.reset": DefaultOffsetReset,
+			},
+		},
+		{
+			name: "legacy environment",
+			env: map[string]string{
+				"KAFKA_BROKERS":           "localhost:9092,localhost:9093",
+				"KAFKA_TOPICS":            "gps-eu, gps-us,",
+				"KAFKA_GROUP_ID":          "dashboard",
+				"KAFKA_OFFSET_RESET":      "latest",
+				"KAFKA_SECURITY_PROTOCOL": "SASL_SSL",
+				"KAFKA_SASL_MECHANISM":    "PLAIN",
+			},
+			topics: []string{"gps-eu", "gps-us"},
+			want: kafka.ConfigMap{
+				"bootstrap.servers": "localhost:9092,localhost:9093",
+				"group.id":          "dashboard",
+				"auto.offset.reset": "latest",
+				"security.protocol": "SASL_SSL",
+				"sasl.mechanism":    "PLAIN",
+			},
+		},
+		{
+			name:   "single topic",
+			env:    map[string]string{"KAFKA_TOPIC": "legacy"},
+			topics: []string{"legacy"},
+		},
+		{
+			name:   "topics list wins over single topic",
+			env:    map[string]string{"KAFKA_TOPIC": "legacy", "KAFKA_TOPICS": "current"},
+			topics: []string{"current"},
+		},
+		{
+			name:   "service environment over legacy",
+			env:    map[string]string{"KAFKA_TOPICS": "legacy", "KAFKA_GROUP_ID": "legacy", "TEST_KAFKA_TOPICS": "gps-eu,gps-us", "TEST_KAFKA_GROUP_ID": "dashboard"},
+			topics: []string{"gps-eu", "gps-us"},
+			want:   kafka.ConfigMap{"group.id": "dashboard"},
+		},
+		{
+			name:   "command line over environment",
+			env:    map[string]string{"TEST_KAFKA_BROKERS": "env:9092", "TEST_KAFKA_SASL_USERNAME": "env"},
+			args:   []string{"-kafka-brokers=flag:9092", "-kafka-topics=gps-eu", "-kafka-sasl-username=flag"},
+			topics: []string{"gps-eu"},
+			want:   kafka.ConfigMap{"bootstrap.servers": "flag:9092", "sasl.username": "flag"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range configEnv {
+				t.Setenv(key, tt.env[key])
+			}
+			for key, v := range tt.env {
+				t.Setenv(key, v)
+			}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			cfg := ConfigFlags(fs)
+			if err := config.Load(fs, "TEST", tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cfg.Topics, tt.topics) {
+				t.Errorf("Topics = %q, want %q", cfg.Topics, tt.topics)
+			}
+			cm := configMap(*cfg)
+			for key, want := range tt.want {
+				if got := cm[key]; got != want {
+					t.Errorf("%s = %v, want %v", key, got, want)
+				}
+			}
+			if cm["enable.auto.commit"] != false {
+				t.Errorf("enable.auto.commit = %v, want false", cm["enable.auto.commit"])
+			}
+		})
+	}
+}
+
+// testTopic is the topic of the mock cluster the consumer tests run against.
+const testTopic = "gps-test"
+
+// startCluster starts an in-process mock Kafka cluster holding values, in
+// order, on partition 0 of testTopic, and returns its bootstrap servers.
+func startCluster(t *testing.T, values ...string) string {
+	t.Helper()
+	mc, err := kafka.NewMockCluster(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mc.Close)
+	produceTo(t, mc.BootstrapServers(), testTopic, values...)
+	return mc.BootstrapServers()
+}
+
+// produceTo produces values, in order, to partition 0 of topic.
+func produceTo(t *testing.T, brokers, topic string, values ...string) {
+	t.Helper()
+	msgs := make([]kafka.Message, len(values))
+	for i, v := range values {
+		msgs[i].Value = []byte(v)
+	}
+	produceMessages(t, brokers, topic, msgs...)
+}
+
+// produceMessages produces msgs, in order, to partition 0 of topic.
+func produceMessages(t *testing.T, brokers, topic string, msgs ...kafka.Message) {
+	t.Helper()
+	p, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": brokers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	reports := make(chan kafka.Event, len(msgs))
+	for _, m := range msgs {
+		m.TopicPartition = kafka.TopicPartition{Topic: &topic, Partition: 0}
+		if err := p.Produce(&m, reports); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range msgs {
+		if m := (<-reports).(*kafka.Message); m.TopicPartition.Error != nil {
+			t.Fatal(m.TopicPartition.Error)
+		}
+	}
+}
+
+// testConfig returns a Config consuming testTopic from brokers, in a group
+// of its own.
+func testConfig(t *testing.T, brokers string) Config {
+	return Config{
+		Brokers:     brokers,
+		Topics:      []string{testTopic},
+		GroupID:     regexp.MustCompile(`\W`).ReplaceAllString(t.Name(), "_"),
+		PollTimeout: 10 * time.Millisecond,
+	}
+}
+
+// committed returns the offset cfg's group has committed for partition 0 of
+// testTopic, or kafka.OffsetInvalid if there is none.
+func committed(t *testing.T, cfg Config) kafka.Offset {
+	t.Helper()
+	cm := configMap(cfg.withDefaults())
+	c, err := kafka.NewConsumer(&cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	topic := testTopic
+	tps, err := c.Committed([]kafka.TopicPartition{{Topic: &topic, Partition: 0}}, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tps[0].Offset
+}
+
+// sinkFunc adapts a function to a Sink.
+type sinkFunc func(context.Context, types.SourceCoords) error
+
+func (f sinkFunc) Write(ctx context.Context, t types.SourceCoords) error { return f(ctx, t) }
+
+// consume runs c with sink until done reports true, checking every few
+// milliseconds, then stops it.
+func consume(t *testing.T, c *KafkaConsumer, sink Sink, done func() bool) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- c.KafkaConsume(ctx, sink) }()
+	deadline := time.Now().Add(30 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the consumer")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUndecodableRecordIsNotCommitted(t *testing.T) {
+	cfg := testConfig(t, startCluster(t, `{"obuid":1,"lat":1,"lon":1}`, `not json`))
+	c, err := NewKafkaConsumer(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := stats.Errors.Load()
+	readings := make(chan types.SourceCoords, 10)
+	sink := sinkFunc(func(_ context.Context, t types.SourceCoords) error {
+		readings <- t
+		return nil
+	})
+	consume(t, c, sink, func() bool { return len(readings) == 1 && stats.Errors.Load() > failed })
+	if got := committed(t, cfg); got != 1 {
+		t.Errorf("committed offset %v, want 1: the first record but not the undecodable one", got)
+	}
+}
+
+func TestRecordsDontShareFields(t *testing.T) {
+	sent := time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)
+	want := []types.SourceCoords{
+		{OBUID: 1, Lat: 48.85, Lon: 2.35, Timestamp: sent, FwVersion: "2.1.0", Seq: 7},
+		{OBUID: 2},
+		{OBUID: 3, Lat: -33.86},
+	}
+	cfg := testConfig(t, startCluster(t,
+		`{"obuid":1,"lat":48.85,"lon":2.35,"timestamp":"2024-05-01T13:00:00Z","fw_version":"2.1.0","seq":7}`,
+		`{"obuid":2}`,
+		`{"obuid":3,"lat":-33.86}`,
+	))
+	c, err := NewKafkaConsumer(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings := make(chan types.SourceCoords, 10)
+	sink := sinkFunc(func(_ context.Context, t types.SourceCoords) error {
+		readings <- t
+		return nil
+	})
+	consume(t, c, sink, func() bool { return len(readings) == len(want) })
+	for i, w := range want {
+		if got := <-readings; !reflect.DeepEqual(got, w) {
+			t.Errorf("reading %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
+
+func TestSendWhenBufferFull(t *testing.T) {
+	t.Run("drops after DropAfter", func(t *testing.T) {
+		c := &KafkaConsumer{msgChan: make(chan delivery, 2), dropWait: 20 * time.Millisecond}
+		for i := 1; i <= 2; i++ {
+			if !c.send(delivery{t: types.SourceCoords{OBUID: i}}) {
+				t.Fatalf("reading %d dropped with room in the buffer", i)
+			}
+		}
+		start := time.Now()
+		if c.send(delivery{t: types.SourceCoords{OBUID: 3}}) {
+			t.Fatal("reading queued into a full buffer")
+		}
+		if waited := time.Since(start); waited < c.dropWait {
+			t.Errorf("dropped after %v, want at least %v", waited, c.dropWait)
+		}
+		if len(c.msgChan) != 2 {
+			t.Errorf("buffer holds %d readings, want 2", len(c.msgChan))
+		}
+	})
+	t.Run("waits for room within DropAfter", func(t *testing.T) {
+		c := &KafkaConsumer{msgChan: make(chan delivery, 1), dropWait: time.Minute}
+		c.send(delivery{t: types.SourceCoords{OBUID: 1}})
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			<-c.msgChan
+		}()
+		if !c.send(delivery{t: types.SourceCoords{OBUID: 2}}) {
+			t.Fatal("reading dropped although the buffer drained in time")
+		}
+	})
+	t.Run("blocks without DropAfter", func(t *testing.T) {
+		c := &KafkaConsumer{msgChan: make(chan delivery, 1)}
+		c.send(delivery{t: types.SourceCoords{OBUID: 1}})
+		sent := make(chan bool)
+		go func() { sent <- c.send(delivery{t: types.SourceCoords{OBUID: 2}}) }()
+		select {
+		case <-sent:
+			t.Fatal("send returned with the buffer full")
+		case <-time.After(50 * time.Millisecond):
+		}
+		<-c.msgChan
+		if !<-sent {
+			t.Error("reading dropped without DropAfter")
+		}
+	})
+}
+
+func TestDeliveryModeCommitOrder(t *testing.T) {
+	tests := []struct {
+		mode DeliveryMode
+		// duringWrite is the offset committed while the sink writes the
+		// only record.
+		duringWrite kafka.Offset
+	}{
+		{AtLeastOnce, kafka.OffsetInvalid},
+		{AtMostOnce, 1},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			cfg := testConfig(t, startCluster(t, `{"obuid":1,"lat":1,"lon":1}`))
+			cfg.Delivery = tt.mode
+			c, err := NewKafkaConsumer(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			topic := testTopic
+			var during []kafka.TopicPartition
+			var duringErr error
+			written := make(chan struct{}, 1)
+			sink := sinkFunc(func(context.Context, types.SourceCoords) error {
+				during, duringErr = c.Consumer.Committed([]kafka.TopicPartition{{Topic: &topic, Partition: 0}}, 5000)
+				written <- struct{}{}
+				return nil
+			})
+			consume(t, c, sink, func() bool { return len(written) == 1 })
+			if duringErr != nil {
+				t.Fatal(duringErr)
+			}
+			if got := during[0].Offset; got != tt.duringWrite {
+				t.Errorf("committed offset %v while writing, want %v", got, tt.duringWrite)
+			}
+			if got := committed(t, cfg); got != 1 {
+				t.Errorf("committed offset %v after writing, want 1", got)
+			}
+		})
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	brokers := startCluster(t, `{"obuid":1}`)
+	tests := []struct {
+		name    string
+		cfg     func(Config) Config
+		wantErr error
+		// consume is set when the error comes from KafkaConsume rather
+		// than NewKafkaConsumer.
+		consume bool
+	}{
+		{"invalid client setting", func(cfg Config) Config {
+			cfg.Security.Protocol = "carrier-pigeon"
+			return cfg
+		}, ErrConsumerInit, false},
+		{"required topic missing", func(cfg Config) Config {
+			cfg.Topics = []string{"no-such-topic"}
+			cfg.RequireTopic = true
+			return cfg
+		}, ErrConsumerInit, false},
+		{"invalid topic pattern", func(cfg Config) Config {
+			cfg.Topics = []string{"^("}
+			return cfg
+		}, ErrSubscribe, true},
+		{"brokers unreachable", func(cfg Config) Config {
+			cfg.Brokers = "127.0.0.1:1"
+			return cfg
+		}, ErrConsume, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewKafkaConsumer(context.Background(), tt.cfg(testConfig(t, brokers)))
+			if tt.consume {
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = c.KafkaConsume(context.Background(), sinkFunc(func(context.Context, types.SourceCoords) error { return nil }))
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOffsetReset(t *testing.T) {
+	tests := []struct {
+		reset string
+		want  string // empty when the value is rejected
+	}{
+		{"", "earliest"},
+		{"earliest", "earliest"},
+		{"latest", "latest"},
+		{"Latest", ""},
+		{"smallest", ""},
+		{"none", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reset, func(t *testing.T) {
+			cfg := Config{Brokers: "127.0.0.1:1", OffsetReset: tt.reset}
+			c, err := NewKafkaConsumer(context.Background(), cfg)
+			if tt.want == "" {
+				if err == nil {
+					c.Consumer.Close()
+					t.Fatalf("offset reset %q accepted", tt.reset)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Consumer.Close()
+			if got := configMap(cfg.withDefaults())["auto.offset.reset"]; got != tt.want {
+				t.Errorf("auto.offset.reset = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsumesEveryTopic(t *testing.T) {
+	brokers := startCluster(t, `{"obuid":1,"lat":1,"lon":1}`)
+	produceTo(t, brokers, "gps-test-eu", `{"obuid":2,"lat":2,"lon":2}`)
+	cfg := testConfig(t, brokers)
+	cfg.Topics = []string{testTopic, "gps-test-eu"}
+	cfg.TagTopic = true
+	c, err := NewKafkaConsumer(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings := make(chan types.SourceCoords, 10)
+	sink := sinkFunc(func(_ context.Context, t types.SourceCoords) error {
+		readings <- t
+		return nil
+	})
+	var subscribed []string
+	consume(t, c, sink, func() bool {
+		if len(subscribed) == 0 {
+			subscribed, _ = c.Consumer.Subscription()
+		}
+		return len(readings) == 2
+	})
+	sort.Strings(subscribed)
+	if !reflect.DeepEqual(subscribed, cfg.Topics) {
+		t.Errorf("subscribed to %q, want %q", subscribed, cfg.Topics)
+	}
+	close(readings)
+	topics := map[int]string{}
+	for r := range readings {
+		topics[r.OBUID] = r.Topic
+	}
+	if want := map[int]string{1: testTopic, 2: "gps-test-eu"}; !reflect.DeepEqual(topics, want) {
+		t.Errorf("got readings by topic %v, want %v", topics, want)
+	}
+}
+
+func TestRebalanceAppliesAssignment(t *testing.T) {
+	cfg := testConfig(t, "127.0.0.1:1")
+	c, err := NewKafkaConsumer(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Consumer.Close()
+	topic := testTopic
+	partitions := []kafka.TopicPartition{{Topic: &topic, Partition: 0}, {Topic: &topic, Partition: 2}}
+	tests := []struct {
+		name string
+		ev   kafka.Event
+		want []string
+	}{
+		{"assigned", kafka.AssignedPartitions{Partitions: partitions}, []string{"gps-test/0", "gps-test/2"}},
+		{"revoked", kafka.RevokedPartitions{Partitions: partitions}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.rebalance(tt.ev)
+			assigned, err := c.Consumer.Assignment()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := partitionNames(assigned); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("assignment %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPollTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		want    time.Duration
+		wantErr bool
+	}{
+		{"default", 0, DefaultPollTimeout, false},
+		{"negative", -time.Second, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, "127.0.0.1:1")
+			cfg.PollTimeout = tt.timeout
+			c, err := NewKafkaConsumer(context.Background(), cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewKafkaConsumer error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer c.Consumer.Close()
+			if c.pollWait != tt.want {
+				t.Errorf("polls wait %v, want %v", c.pollWait, tt.want)
+			}
+		})
+	}
+}
+
+func TestBufferSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		buffer  int
+		want    int
+		wantErr bool
+	}{
+		{"default", 0, DefaultBuffer, false},
+		{"custom", 10, 10, false},
+		{"negative", -1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, "127.0.0.1:1")
+			cfg.Buffer = tt.buffer
+			c, err := NewKafkaConsumer(context.Background(), cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewKafkaConsumer error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer c.Consumer.Close()
+			if got := cap(c.msgChan); got != tt.want {
+				t.Errorf("buffer holds %d readings, want %d", got, tt.want)
+			}
+		})
+	}
+	if _, err := NewMultiSink(-1); err == nil {
+		t.Error("NewMultiSink accepted a negative buffer")
+	}
+}
+
+func TestDecodeBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     kafka.Message
+		want    []types.SourceCoords
+		wantErr bool
+	}{
+		{
+			"single reading",
+			kafka.Message{Value: []byte(`{"obuid":1,"lat":1,"lon":1}`)},
+			[]types.SourceCoords{{OBUID: 1, Lat: 1, Lon: 1}},
+			false,
+		},
+		{
+			"batch",
+			kafka.Message{
+				Value:   []byte(`[{"obuid":1,"lat":1,"lon":1},{"obuid":1,"lat":2,"lon":2},{"obuid":1,"lat":3,"lon":3}]`),
+				Headers: []kafka.Header{{Key: types.HeaderBatch, Value: []byte("3")}},
+			},
+			[]types.SourceCoords{{OBUID: 1, Lat: 1, Lon: 1}, {OBUID: 1, Lat: 2, Lon: 2}, {OBUID: 1, Lat: 3, Lon: 3}},
+			false,
+		},
+		{
+			"array without the batch header",
+			kafka.Message{Value: []byte(`[{"obuid":1,"lat":1,"lon":1}]`)},
+			nil,
+			true,
+		},
+		{
+			"malformed batch",
+			kafka.Message{Value: []byte(`[{"obuid":1,"lat":1,"lon":1},{"lat":2}]`), Headers: []kafka.Header{{Key: types.HeaderBatch, Value: []byte("2")}}},
+			nil,
+			true,
+		},
+	}
+	c := &KafkaConsumer{codec: types.JSONCodec{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.decode(&tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decode error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decode = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxMessagesCommitsWhereItStopped(t *testing.T) {
+	batch := kafka.Message{
+		Value:   []byte(`[{"obuid":1,"lat":1,"lon":1},{"obuid":2,"lat":2,"lon":2},{"obuid":3,"lat":3,"lon":3}]`),
+		Headers: []kafka.Header{{Key: types.HeaderBatch, Value: []byte("3")}},
+	}
+	tests := []struct {
+		name          string
+		max           int
+		wantCommitted kafka.Offset
+	}{
+		// The limit cuts the batch short, so its last reading is never
+		// handed over; the record is committed at the second one instead.
+		{"mid batch", 2, 1},
+		{"end of batch", 3, 1},
+		{"next record", 4, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := kafka.NewMockCluster(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer mc.Close()
+			produceMessages(t, mc.BootstrapServers(), testTopic, batch, kafka.Message{Value: []byte(`{"obuid":4,"lat":4,"lon":4}`)})
+			cfg := testConfig(t, mc.BootstrapServers())
+			cfg.MaxMessages = tt.max
+			c, err := NewKafkaConsumer(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var written int
+			sink := sinkFunc(func(context.Context, types.SourceCoords) error {
+				written++
+				return nil
+			})
+			errc := make(chan error, 1)
+			go func() { errc <- c.KafkaConsume(context.Background(), sink) }()
+			select {
+			case err := <-errc:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(30 * time.Second):
+				t.Fatal("consumer didn't stop at the limit")
+			}
+			if written != tt.max {
+				t.Errorf("sink got %d readings, want %d", written, tt.max)
+			}
+			if got := committed(t, cfg); got != tt.wantCommitted {
+				t.Errorf("committed offset %v, want %v", got, tt.wantCommitted)
+			}
+		})
+	}
+}
+
+// TestPollTimeoutBoundsShutdown cancels consumers polling an idle topic: the
+// poll in progress runs out before KafkaConsume returns, so a short timeout
+// stops it promptly and a long one holds it up.
+func TestPollTimeoutBoundsShutdown(t *testing.T) {
+	tests := []struct {
+		timeout  time.Duration
+		min, max time.Duration
+	}{
+		{50 * time.Millisecond, 0, time.Second},
+		{3 * time.Second, time.Second, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.timeout.String(), func(t *testing.T) {
+			cfg := testConfig(t, startCluster(t, `{"obuid":1,"lat":1,"lon":1}`))
+			cfg.PollTimeout = tt.timeout
+			c, err := NewKafkaConsumer(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			got := make(chan struct{}, 1)
+			errc := make(chan error, 1)
+			go func() {
+				errc <- c.KafkaConsume(ctx, sinkFunc(func(context.Context, types.SourceCoords) error {
+					got <- struct{}{}
+					return nil
+				}))
+			}()
+			select {
+			case <-got:
+			case <-time.After(30 * time.Second):
+				t.Fatal("timed out waiting for the consumer")
+			}
+			// The topic is idle from here on, so the consumer sits in a poll.
+			time.Sleep(500 * time.Millisecond)
+			cancel()
+			start := time.Now()
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+			if took := time.Since(start); took < tt.min || took > tt.max {
+				t.Errorf("KafkaConsume returned %v after cancellation, want between %v and %v", took, tt.min, tt.max)
+			}
+		})
+	}
+}
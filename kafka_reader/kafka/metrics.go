@@ -0,0 +1,41 @@
+package kafka
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	messagesConsumed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kafka_messages_consumed_total",
+		Help: "Messages consumed and handed to the application.",
+	})
+	consumeErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kafka_consume_errors_total",
+		Help: "Messages that could not be decoded and consumer errors.",
+	})
+	heartbeatsConsumed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kafka_heartbeats_consumed_total",
+		Help: "Heartbeat readings seen and filtered out of the stream.",
+	})
+	messagesDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kafka_messages_dropped_total",
+		Help: "Readings dropped because the handlers fell behind.",
+	})
+	deadLettered = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kafka_dead_lettered_total",
+		Help: "Undecodable messages republished to the dead-letter topic.",
+	})
+	sinkErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kafka_sink_errors_total",
+		Help: "Readings the sink failed to write.",
+	})
+	sinkDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kafka_sink_dropped_total",
+		Help: "Readings an optional sink missed because it fell behind.",
+	})
+	consumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kafka_consumer_lag",
+		Help: "Messages between the consumer's position and the high watermark, per assigned partition.",
+	}, []string{"topic", "partition"})
+)
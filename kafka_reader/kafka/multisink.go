@@ -0,0 +1,146 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/erastusk/gpscords/stats"
+	"github.com/erastusk/gpscords/types"
+)
+
+// MultiSink writes every reading to several sinks concurrently.
+//
+// Required sinks are written to directly, and Write returns only once all of
+// them have finished, with their errors joined. WriteAsync only queues
+// readings with required BatchSinks, reporting once those have stored them
+// too. KafkaConsume commits a record only if all of them succeeded; see Sink
+// for what happens when one fails.
+//
+// Optional sinks each get a goroutine fed through a buffer, so a slow or
+// failing one never holds up the others or the commit. Their errors are
+// logged and counted, and readings are dropped while their buffer is full.
+type MultiSink struct {
+	buffer   int
+	required []Sink
+	optional []*optionalSink
+	wg       sync.WaitGroup
+	once     sync.Once
+}
+
+type optionalSink struct {
+	sink Sink
+	in   chan types.SourceCoords
+}
+
+// NewMultiSink returns an empty MultiSink buffering up to buffer readings
+// for each optional sink.
+func NewMultiSink(buffer int) (*MultiSink, error) {
+	if buffer < 0 {
+		return nil, fmt.Errorf("sink buffer %d is negative", buffer)
+	}
+	return &MultiSink{buffer: buffer}, nil
+}
+
+// Add registers s. It must be called before the first Write.
+func (m *MultiSink) Add(s Sink, required bool) {
+	if required {
+		m.required = append(m.required, s)
+		return
+	}
+	o := &optionalSink{sink: s, in: make(chan types.SourceCoords, m.buffer)}
+	m.optional = append(m.optional, o)
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		for t := range o.in {
+			if err := s.Write(context.Background(), t); err != nil {
+				slog.Error("Optional sink couldn't write reading", slog.Int("obuid", t.OBUID), slog.Any("err", err))
+				stats.Errors.Add(1)
+				sinkErrors.Inc()
+			}
+		}
+	}()
+}
+
+func (m *MultiSink) Write(ctx context.Context, t types.SourceCoords) error {
+	m.writeOptional(t)
+	return writeAll(ctx, m.required, t)
+}
+
+// WriteAsync makes m a BatchSink. It queues t with the required BatchSinks
+// and writes it to the other required sinks, then calls done with all their
+// errors joined once the last of them has finished.
+func (m *MultiSink) WriteAsync(ctx context.Context, t types.SourceCoords, done func(error)) {
+	m.writeOptional(t)
+	var direct []Sink
+	var batched []BatchSink
+	for _, s := range m.required {
+		if b, ok := s.(BatchSink); ok {
+			batched = append(batched, b)
+		} else {
+			direct = append(direct, s)
+		}
+	}
+	var mu sync.Mutex
+	left := len(batched) + 1
+	var errs []error
+	finish := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		left--
+		last := left == 0
+		mu.Unlock()
+		if last {
+			done(errors.Join(errs...))
+		}
+	}
+	for _, b := range batched {
+		b.WriteAsync(ctx, t, finish)
+	}
+	finish(writeAll(ctx, direct, t))
+}
+
+func (m *MultiSink) writeOptional(t types.SourceCoords) {
+	for _, o := range m.optional {
+		select {
+		case o.in <- t:
+		default:
+			sinkDropped.Inc()
+		}
+	}
+}
+
+// writeAll writes t to every sink concurrently, joining their errors.
+func writeAll(ctx context.Context, sinks []Sink, t types.SourceCoords) error {
+	switch len(sinks) {
+	case 0:
+		return nil
+	case 1:
+		return sinks[0].Write(ctx, t)
+	}
+	errs := make([]error, len(sinks))
+	var wg sync.WaitGroup
+	for i, s := range sinks {
+		wg.Add(1)
+		go func(i int, s Sink) {
+			defer wg.Done()
+			errs[i] = s.Write(ctx, t)
+		}(i, s)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Close waits for the optional sinks to write what they have buffered. It
+// doesn't close the sinks themselves.
+func (m *MultiSink) Close() {
+	m.once.Do(func() {
+		for _, o := range m.optional {
+			close(o.in)
+		}
+		m.wg.Wait()
+	})
+}
@@ -0,0 +1,172 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+var errSinkDown = errors.New("sink down")
+
+func failing(context.Context, types.SourceCoords) error { return errSinkDown }
+
+// recording returns a sink passing what it's given to readings.
+func recording(readings chan<- types.SourceCoords) sinkFunc {
+	return func(_ context.Context, t types.SourceCoords) error {
+		readings <- t
+		return nil
+	}
+}
+
+func TestMultiSinkIsolatesFailures(t *testing.T) {
+	tests := []struct {
+		name            string
+		failingRequired bool
+		wantErr         error
+	}{
+		{"failing required sink", true, errSinkDown},
+		{"failing optional sink", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readings := make(chan types.SourceCoords, 1)
+			m, err := NewMultiSink(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.Add(sinkFunc(failing), tt.failingRequired)
+			m.Add(recording(readings), true)
+			err = m.Write(context.Background(), types.SourceCoords{OBUID: 7})
+			m.Close()
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Write error = %v, want %v", err, tt.wantErr)
+			}
+			select {
+			case got := <-readings:
+				if got.OBUID != 7 {
+					t.Errorf("healthy sink got OBU %d, want 7", got.OBUID)
+				}
+			default:
+				t.Error("healthy sink got nothing")
+			}
+		})
+	}
+}
+
+func TestMultiSinkDoesntWaitForOptionalSinks(t *testing.T) {
+	release := make(chan struct{})
+	stuck := sinkFunc(func(context.Context, types.SourceCoords) error {
+		<-release
+		return nil
+	})
+	readings := make(chan types.SourceCoords, 10)
+	optional := make(chan types.SourceCoords, 10)
+	m, err := NewMultiSink(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Add(stuck, false)
+	m.Add(recording(optional), false)
+	m.Add(recording(readings), true)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 5; i++ {
+			if err := m.Write(context.Background(), types.SourceCoords{OBUID: i}); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write blocked on a stuck optional sink")
+	}
+	if len(readings) != 5 {
+		t.Errorf("required sink got %d readings, want 5", len(readings))
+	}
+	close(release)
+	m.Close()
+	if len(optional) == 0 {
+		t.Error("healthy optional sink got nothing")
+	}
+}
+
+func TestFailedRequiredSinkIsNotCommitted(t *testing.T) {
+	tests := []struct {
+		name          string
+		deadLetter    bool
+		wantErr       error
+		wantCommitted kafka.Offset
+		wantWritten   int
+	}{
+		// OBU 2 stays where it is and OBU 3 behind it is never reached.
+		{"left for redelivery", false, ErrSink, 1, 1},
+		{"dead-lettered", true, nil, 3, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, startCluster(t,
+				`{"obuid":1,"lat":1,"lon":1}`, `{"obuid":2,"lat":2,"lon":2}`, `{"obuid":3,"lat":3,"lon":3}`))
+			if tt.deadLetter {
+				cfg.DeadLetterTopic = "gps-test-dlq"
+				cfg.DeadLetterSinkErrors = true
+			}
+			c, err := NewKafkaConsumer(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			readings := make(chan types.SourceCoords, 10)
+			m, err := NewMultiSink(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.Add(sinkFunc(func(_ context.Context, t types.SourceCoords) error {
+				if t.OBUID == 2 {
+					return errSinkDown
+				}
+				return nil
+			}), true)
+			m.Add(recording(readings), true)
+			defer m.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			errc := make(chan error, 1)
+			go func() { errc <- c.KafkaConsume(ctx, m) }()
+			if tt.wantErr == nil {
+				deadline := time.Now().Add(30 * time.Second)
+				for len(readings) < 3 && time.Now().Before(deadline) {
+					time.Sleep(5 * time.Millisecond)
+				}
+				cancel()
+			}
+			select {
+			case err := <-errc:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("KafkaConsume error = %v, want %v", err, tt.wantErr)
+				}
+			case <-time.After(30 * time.Second):
+				t.Fatal("consumer didn't stop")
+			}
+			if got := committed(t, cfg); got != tt.wantCommitted {
+				t.Errorf("committed offset %v, want %v", got, tt.wantCommitted)
+			}
+			var written int
+			for len(readings) > 0 {
+				if r := <-readings; r.OBUID != 2 {
+					written++
+				}
+			}
+			if written != tt.wantWritten {
+				t.Errorf("%d readings written besides OBU 2, want %d", written, tt.wantWritten)
+			}
+		})
+	}
+}
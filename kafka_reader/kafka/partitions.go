@@ -0,0 +1,102 @@
+package kafka
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// PartitionState describes one assigned partition. Offsets that aren't known
+// yet, for example before the first fetch or commit, are -1, and so is a lag
+// that can't be computed.
+type PartitionState struct {
+	Topic         string `json:"topic"`
+	Partition     int32  `json:"partition"`
+	Position      int64  `json:"position"`
+	Committed     int64  `json:"committed"`
+	HighWatermark int64  `json:"high_watermark"`
+	Lag           int64  `json:"lag"`
+}
+
+func knownOffset(o kafka.Offset) int64 {
+	if o < 0 {
+		return -1
+	}
+	return int64(o)
+}
+
+// PartitionStates reports the consumer's current assignment. The librdkafka
+// calls it makes are safe alongside Poll, so it doesn't stall consumption;
+// timeout bounds the broker round trips.
+func (c *KafkaConsumer) PartitionStates(timeout time.Duration) ([]PartitionState, error) {
+	assigned, err := c.Consumer.Assignment()
+	if err != nil || len(assigned) == 0 {
+		return nil, err
+	}
+	positions, err := c.Consumer.Position(assigned)
+	if err != nil {
+		return nil, err
+	}
+	committed, err := c.Consumer.Committed(assigned, int(timeout.Milliseconds()))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]PartitionState, len(assigned))
+	for i, tp := range assigned {
+		s := PartitionState{
+			Topic:         *tp.Topic,
+			Partition:     tp.Partition,
+			Position:      knownOffset(positions[i].Offset),
+			Committed:     knownOffset(committed[i].Offset),
+			HighWatermark: -1,
+			Lag:           -1,
+		}
+		_, high, err := c.Consumer.QueryWatermarkOffsets(*tp.Topic, tp.Partition, int(timeout.Milliseconds()))
+		if err == nil {
+			s.HighWatermark = high
+			switch {
+			case s.Position >= 0:
+				s.Lag = high - s.Position
+			case s.Committed >= 0:
+				s.Lag = high - s.Committed
+			}
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+// exportLag refreshes the kafka_consumer_lag gauge every interval until ctx
+// is done. Partitions no longer assigned, or whose lag is unknown, are
+// dropped from the gauge.
+func (c *KafkaConsumer) exportLag(ctx context.Context, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		states, err := c.PartitionStates(interval)
+		if err != nil {
+			slog.Warn("Couldn't fetch partition offsets for lag", slog.Any("err", err))
+			continue
+		}
+		setLag(states)
+	}
+}
+
+// setLag replaces the kafka_consumer_lag series with those of states.
+func setLag(states []PartitionState) {
+	consumerLag.Reset()
+	for _, s := range states {
+		if s.Lag < 0 {
+			continue
+		}
+		consumerLag.WithLabelValues(s.Topic, strconv.Itoa(int(s.Partition))).Set(float64(s.Lag))
+	}
+}
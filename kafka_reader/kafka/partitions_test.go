@@ -0,0 +1,61 @@
+package kafka
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrapeLag returns the kafka_consumer_lag series, keyed by topic/partition,
+// as the default registry would serve them.
+func scrapeLag(t *testing.T) map[string]float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]float64{}
+	for _, f := range families {
+		if f.GetName() != "kafka_consumer_lag" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			out[labels["topic"]+"/"+labels["partition"]] = m.GetGauge().GetValue()
+		}
+	}
+	return out
+}
+
+func TestSetLag(t *testing.T) {
+	defer consumerLag.Reset()
+	tests := []struct {
+		name   string
+		states []PartitionState
+		want   map[string]float64
+	}{
+		{
+			"two partitions",
+			[]PartitionState{{Topic: "gps", Partition: 0, Lag: 5}, {Topic: "gps", Partition: 1, Lag: 0}},
+			map[string]float64{"gps/0": 5, "gps/1": 0},
+		},
+		{
+			"partition revoked and lag unknown",
+			[]PartitionState{{Topic: "gps", Partition: 0, Lag: 7}, {Topic: "gps", Partition: 2, Lag: -1}},
+			map[string]float64{"gps/0": 7},
+		},
+		{"nothing assigned", nil, map[string]float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setLag(tt.states)
+			if got := scrapeLag(t); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scraped %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
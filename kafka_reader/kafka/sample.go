@@ -0,0 +1,22 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+)
+
+// sampled reports whether the message at offset carrying obuid falls inside
+// rate. The decision only depends on its inputs, so replaying a topic selects
+// the same messages every time.
+func sampled(obuid int, offset int64, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(obuid))
+	binary.BigEndian.PutUint64(buf[8:], uint64(offset))
+	h := fnv.New64a()
+	h.Write(buf[:])
+	return float64(h.Sum64()) < rate*math.MaxUint64
+}
@@ -0,0 +1,37 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+// Sink is where KafkaConsume delivers readings once the handlers have seen
+// them. A reading is committed only after Write succeeds. Failures are logged
+// and counted; the record is then dead-lettered and committed when
+// Config.DeadLetterSinkErrors is set, and otherwise left uncommitted while
+// KafkaConsume stops with ErrSink.
+type Sink interface {
+	Write(context.Context, types.SourceCoords) error
+}
+
+// BatchSink is a Sink that stores readings in batches, in the background.
+// KafkaConsume hands it readings with WriteAsync, which returns once t is
+// queued, and commits each reading's record when done reports it stored, so
+// one slow insert covers many readings. done must be called exactly once for
+// every reading, in the order they were written.
+type BatchSink interface {
+	Sink
+	WriteAsync(ctx context.Context, t types.SourceCoords, done func(error))
+}
+
+// StdoutSink writes every reading to standard output as a line of JSON. It
+// is the Sink KafkaConsume uses when given none.
+type StdoutSink struct{}
+
+func (StdoutSink) Write(_ context.Context, t types.SourceCoords) error {
+	// Encode makes a single write per reading, so lines never interleave.
+	return json.NewEncoder(os.Stdout).Encode(t)
+}
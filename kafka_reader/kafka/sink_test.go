@@ -0,0 +1,91 @@
+package kafka
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+func TestSinkReceivesReadings(t *testing.T) {
+	want := []types.SourceCoords{
+		{OBUID: 1, Lat: 1, Lon: 1},
+		{OBUID: 2, Lat: 2, Lon: 2, Timestamp: time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC), FwVersion: "2.1.0", Seq: 7},
+		{OBUID: 1, Lat: 1.5, Lon: 1.5},
+	}
+	values := make([]string, len(want))
+	for i, r := range want {
+		b, err := types.JSONCodec{}.Encode(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values[i] = string(b)
+	}
+	c, err := NewKafkaConsumer(context.Background(), testConfig(t, startCluster(t, values...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings := make(chan types.SourceCoords, 10)
+	sink := sinkFunc(func(_ context.Context, t types.SourceCoords) error {
+		readings <- t
+		return nil
+	})
+	consume(t, c, sink, func() bool { return len(readings) == len(want) })
+	close(readings)
+	var got []types.SourceCoords
+	for r := range readings {
+		got = append(got, r)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sink got %+v, want %+v", got, want)
+	}
+}
+
+// heldSink is a BatchSink holding every reading until release is closed.
+type heldSink struct {
+	release chan struct{}
+	queued  chan types.SourceCoords
+}
+
+func (h heldSink) Write(context.Context, types.SourceCoords) error { return nil }
+
+func (h heldSink) WriteAsync(_ context.Context, t types.SourceCoords, done func(error)) {
+	h.queued <- t
+	go func() {
+		<-h.release
+		done(nil)
+	}()
+}
+
+func TestBatchSinkCommitsOnceStored(t *testing.T) {
+	cfg := testConfig(t, startCluster(t, `{"obuid":1,"lat":1,"lon":1}`, `{"obuid":2,"lat":2,"lon":2}`))
+	c, err := NewKafkaConsumer(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := heldSink{release: make(chan struct{}), queued: make(chan types.SourceCoords, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- c.KafkaConsume(ctx, sink) }()
+	deadline := time.Now().Add(30 * time.Second)
+	for len(sink.queued) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := committed(t, cfg); got != kafka.OffsetInvalid {
+		t.Errorf("committed offset %v before the batch was stored, want none", got)
+	}
+	// KafkaConsume waits for the batch before closing the consumer.
+	cancel()
+	close(sink.release)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if got := committed(t, cfg); got != 2 {
+		t.Errorf("committed offset %v once the batch was stored, want 2", got)
+	}
+}
@@ -0,0 +1,66 @@
+package kafka
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	clockSkew = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kafka_producer_clock_skew_seconds",
+		Help:    "Record timestamp minus reading timestamp. Positive values mean the OBU clock is behind.",
+		Buckets: []float64{-300, -60, -10, -1, -0.1, 0, 0.1, 1, 10, 60, 300},
+	})
+	clockSkewExceeded = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kafka_producer_clock_skew_exceeded_total",
+		Help: "Readings whose clock skew exceeded the configured threshold.",
+	})
+)
+
+const (
+	// skewWarnEvery limits warnings to one per OBU per interval.
+	skewWarnEvery = time.Minute
+	// skewMaxTracked bounds the per-OBU warning state.
+	skewMaxTracked = 10000
+)
+
+// skewMonitor compares reading timestamps with the record timestamps Kafka
+// assigns. With the default CreateTime that is the receiver's clock at
+// produce time, so the skew also includes delivery latency. The OBU is only
+// named in logs; metrics stay unlabeled to avoid one series per vehicle.
+type skewMonitor struct {
+	threshold time.Duration
+	mu        sync.Mutex
+	warned    map[int]time.Time
+}
+
+func newSkewMonitor(threshold time.Duration) *skewMonitor {
+	return &skewMonitor{threshold: threshold, warned: make(map[int]time.Time)}
+}
+
+func (m *skewMonitor) observe(obuid int, reading, record time.Time) {
+	if reading.IsZero() || record.IsZero() {
+		return
+	}
+	skew := record.Sub(reading)
+	clockSkew.Observe(skew.Seconds())
+	if time.Duration(math.Abs(float64(skew))) <= m.threshold {
+		return
+	}
+	clockSkewExceeded.Inc()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if time.Since(m.warned[obuid]) < skewWarnEvery {
+		return
+	}
+	if len(m.warned) >= skewMaxTracked {
+		m.warned = make(map[int]time.Time)
+	}
+	m.warned[obuid] = time.Now()
+	slog.Warn("OBU clock is off", slog.Int("obuid", obuid), slog.Duration("skew", skew))
+}
@@ -0,0 +1,38 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/erastusk/gpscords/logging"
+)
+
+func TestSkewWarningNamesOBU(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logging.New(&buf, "test", "info", "json"))
+
+	m := newSkewMonitor(time.Second)
+	record := time.Now()
+	m.observe(42, record.Add(-time.Minute), record)
+	m.observe(42, record.Add(-time.Minute), record)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 1 {
+		t.Fatalf("got %d log lines, want 1 warning per OBU per interval:\n%s", len(lines), buf.Bytes())
+	}
+	var entry struct {
+		Level string `json:"level"`
+		OBUID int    `json:"obuid"`
+		Skew  int64  `json:"skew"`
+	}
+	if err := json.Unmarshal(lines[0], &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Level != "WARN" || entry.OBUID != 42 || time.Duration(entry.Skew) != time.Minute {
+		t.Errorf("got %s, want a warning with obuid 42 and skew 1m", lines[0])
+	}
+}
@@ -1,18 +1,230 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/erastusk/gpscords/config"
+	"github.com/erastusk/gpscords/kafka_reader/enrich"
+	"github.com/erastusk/gpscords/kafka_reader/eta"
+	"github.com/erastusk/gpscords/kafka_reader/gapfill"
+	"github.com/erastusk/gpscords/kafka_reader/geofence"
+	"github.com/erastusk/gpscords/kafka_reader/influx"
 	"github.com/erastusk/gpscords/kafka_reader/kafka"
+	"github.com/erastusk/gpscords/kafka_reader/parquetsink"
+	"github.com/erastusk/gpscords/kafka_reader/pgsink"
+	"github.com/erastusk/gpscords/kafka_reader/positions"
+	"github.com/erastusk/gpscords/kafka_reader/stops"
+	"github.com/erastusk/gpscords/kafka_reader/stream"
+	"github.com/erastusk/gpscords/logging"
+	"github.com/erastusk/gpscords/stats"
+	"github.com/erastusk/gpscords/types"
+)
+
+var (
+	addr               = flag.String("addr", "localhost:30001", "http service address")
+	assignmentStrategy = flag.String("assignment-strategy", "", "partition assignment strategy: range, roundrobin or cooperative-sticky")
+	sampleRate         = flag.Float64("sample-rate", 1.0, "fraction of messages to process, selected deterministically")
+	heartbeatID        = flag.Int("heartbeat-obuid", 0, "sentinel OBUID of heartbeat readings to filter out, normally -1 (0 disables)")
+	enrichOut          = flag.Bool("enrich", false, "log each reading with derived speed and heading")
+	accelThreshold     = flag.Float64("accel-threshold", 0, "report acceleration or braking of at least this many m/s² (0 disables)")
+	accelMinDelta      = flag.Duration("accel-min-delta", 2*time.Second, "minimum time between readings used for acceleration")
+	stopRadius         = flag.Float64("stop-radius", 0, "radius in meters an OBU must stay within to count as stopped (0 disables stop detection)")
+	stopDuration       = flag.Duration("stop-duration", 5*time.Minute, "how long an OBU must stay within -stop-radius to count as stopped")
+	maxMessages        = flag.Int("max-messages", 0, "exit after consuming this many messages (0 means run forever)")
+	requireTopic       = flag.Bool("require-topic", false, "exit at startup if a topic doesn't exist")
+	tagTopic           = flag.Bool("tag-topic", false, "tag readings with the topic they were consumed from")
+	skewThreshold      = flag.Duration("skew-threshold", 0, "warn about OBUs whose clocks differ from broker time by more than this (0 disables)")
+	dlqTopic           = flag.String("dlq-topic", "", "republish undecodable messages to this topic, e.g. gpscoords.dlq (empty drops them)")
+	dlqSink            = flag.Bool("dlq-sink-errors", false, "also dead-letter records the sink fails to write (needs -dlq-topic)")
+	buffer             = flag.Int("buffer", kafka.DefaultBuffer, "readings buffered between polling and the handlers")
+	dropAfter          = flag.Duration("drop-after", 0, "drop readings when the buffer stays full this long, giving up at-least-once delivery (0 waits)")
+	enableDebug        = flag.Bool("enable-debug", false, "UNSAFE for production: enable POST /debug/inject")
+	pushgateway        = flag.String("pushgateway", "", "Pushgateway URL to push final metrics to on shutdown")
+	pushJob            = flag.String("push-job", "kafka_reader", "job label used when pushing metrics")
+	influxURL          = flag.String("influx-url", "", "InfluxDB write endpoint; enables the Influx sink")
+	influxToken        = flag.String("influx-token", "", "InfluxDB API token")
+	influxBatch        = flag.Int("influx-batch", 500, "points per Influx write")
+	influxFlush        = flag.Duration("influx-flush", 5*time.Second, "maximum time a point waits before being written to Influx")
+	geofences          = flag.String("geofences", "", "JSON file of circular or bounding-box geofences; logs enter/exit events, reloaded on SIGHUP and served at GET /geofences")
+	parquetDir         = flag.String("parquet-dir", "", "write readings as date/hour partitioned Parquet files below this directory")
+	parquetRows        = flag.Int("parquet-rows", 100000, "rows buffered before Parquet files are written")
+	parquetFlush       = flag.Duration("parquet-flush", time.Minute, "maximum time a row waits before being written to Parquet")
+	stdoutSink         = flag.Bool("stdout", false, "also print readings to stdout when a database sink is enabled")
+	sinkBuffer         = flag.Int("sink-buffer", 1000, "readings buffered for each optional sink before it misses some")
+	pgDSN              = flag.String("pg-dsn", "", "PostgreSQL connection string; enables the PostGIS sink in place of stdout")
+	pgTable            = flag.String("pg-table", "positions", "table the PostGIS sink inserts into")
+	pgBatch            = flag.Int("pg-batch", 500, "readings per Postgres insert")
+	pgFlush            = flag.Duration("pg-flush", 5*time.Second, "maximum time a reading waits before being inserted into Postgres")
+	gapThreshold       = flag.Duration("gap-threshold", 0, "interpolate points into the Influx sink across gaps longer than this (0 disables)")
+	gapSpacing         = flag.Duration("gap-spacing", 10*time.Second, "spacing of interpolated points")
+	snapshotFile       = flag.String("snapshot-file", "", "periodically write a GeoJSON snapshot of the latest positions to this file")
+	snapshotEvery      = flag.Duration("snapshot-interval", 30*time.Second, "how often -snapshot-file is rewritten")
+	etaDest            = flag.String("eta-dest", "", "destination fence as lat,lon,radius_m; enables GET /obus/{id}/eta")
+	etaStale           = flag.Duration("eta-stale", 2*time.Minute, "positions older than this produce no ETA")
+	streamBuffer       = flag.Int("stream-buffer", 100, "readings buffered per GET /stream client before it misses some")
+	deliveryMode       = flag.String("delivery", string(kafka.AtLeastOnce), "commit offsets after processing (at-least-once) or before it (at-most-once)")
+	pollTimeout        = flag.Duration("poll-timeout", kafka.DefaultPollTimeout, "how long each poll waits for records; shorter reacts to shutdown sooner but wakes more often when idle")
+	lagInterval        = flag.Duration("lag-interval", 15*time.Second, "how often the kafka_consumer_lag gauge is refreshed (0 disables)")
+
+	kafkaCfg      = kafka.ConfigFlags(flag.CommandLine)
+	serialization = config.SerializationFlags(flag.CommandLine)
 )
 
 func main() {
-	c, err := kafka.NewKafkaConsumer()
+	logging.Setup("reader")
+	config.MustLoad("READER")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cfg := *kafkaCfg
+	cfg.AssignmentStrategy = *assignmentStrategy
+	cfg.SampleRate = *sampleRate
+	cfg.HeartbeatOBUID = *heartbeatID
+	cfg.RequireTopic = *requireTopic
+	cfg.MaxMessages = *maxMessages
+	cfg.SkewThreshold = *skewThreshold
+	cfg.DeadLetterTopic = *dlqTopic
+	cfg.DeadLetterSinkErrors = *dlqSink
+	cfg.Buffer = *buffer
+	cfg.DropAfter = *dropAfter
+	cfg.LagInterval = *lagInterval
+	cfg.PollTimeout = *pollTimeout
+	cfg.Delivery = kafka.DeliveryMode(*deliveryMode)
+	cfg.TagTopic = *tagTopic
+	// Avro decoding finds schemas by the ID in each record, so any of the
+	// topics will do.
+	codec, err := serialization.Codec(cfg.Topics[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.Codec = codec
+	c, err := kafka.NewKafkaConsumer(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *geofences != "" {
+		fences, err := geofence.NewSet(*geofences)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fences.ReloadOnSIGHUP()
+		http.Handle("/geofences", fences)
+		c.OnMessage(geofence.NewTracker(fences, func(e geofence.Event) {
+			slog.Info("Geofence event", slog.Any("event", e))
+		}).Observe)
+	}
+	if *etaDest != "" {
+		dest, err := eta.ParseFence(*etaDest)
+		if err != nil {
+			log.Fatal(err)
+		}
+		est := eta.NewEstimator(dest, *etaStale)
+		c.OnMessage(est.Observe)
+		http.Handle("/obus/", est)
+	}
+	http.HandleFunc("/partitions", partitionsHandler(c))
+	http.Handle("/metrics", promhttp.Handler())
+	hub, err := stream.NewHub(*streamBuffer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.OnMessage(hub.Observe)
+	http.Handle("/stream", hub)
+	store := positions.NewStore()
+	c.OnMessage(store.Observe)
+	http.HandleFunc("/snapshot.geojson", store.ServeGeoJSON)
+	http.HandleFunc("/position/", store.ServePosition)
+	http.HandleFunc("/positions", store.ServePositions)
+	if *snapshotFile != "" {
+		go store.WriteGeoJSONEvery(*snapshotFile, *snapshotEvery)
+	}
+	if *enrichOut {
+		in := make(chan types.SourceCoords, 100)
+		c.OnMessage(func(t types.SourceCoords) { in <- t })
+		go func() {
+			for e := range enrich.NewEnricher().Run(in) {
+				slog.Info("Enriched", slog.Any("reading", e))
+			}
+		}()
+	}
+	if *accelThreshold > 0 {
+		d := enrich.NewAccelDetector(*accelThreshold, *accelMinDelta, func(e enrich.AccelEvent) {
+			slog.Info("Acceleration event", slog.Any("event", e))
+		})
+		c.OnMessage(d.Observe)
+	}
+	if *stopRadius > 0 {
+		d := stops.NewDetector(*stopRadius, *stopDuration, func(e stops.Event) {
+			slog.Info("Stop event", slog.Any("event", e))
+		})
+		c.OnMessage(d.Observe)
+	}
+	if *influxURL != "" {
+		sink, err := influx.NewSink(*influxURL, *influxToken, *influxBatch, *influxFlush)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *gapThreshold > 0 {
+			f, err := gapfill.NewFiller(*gapThreshold, *gapSpacing, sink.Write)
+			if err != nil {
+				log.Fatal(err)
+			}
+			c.OnMessage(f.Observe)
+		} else {
+			c.OnMessage(sink.Observe)
+		}
+		stats.OnShutdown(sink.Close)
+	}
+	if *parquetDir != "" {
+		sink, err := parquetsink.NewSink(*parquetDir, *parquetRows, *parquetFlush)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.OnMessage(sink.Observe)
+		stats.OnShutdown(sink.Close)
+	}
+	// The fan-out drains before the sinks it feeds are closed.
+	sink, err := kafka.NewMultiSink(*sinkBuffer)
 	if err != nil {
-		log.Println(err)
+		log.Fatal(err)
+	}
+	stats.OnShutdown(sink.Close)
+	if *pgDSN != "" {
+		pg, err := pgsink.NewSink(*pgDSN, *pgTable, *pgBatch, *pgFlush)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sink.Add(pg, true)
+		stats.OnShutdown(pg.Close)
+	}
+	if *pgDSN == "" || *stdoutSink {
+		// Stdout is only for debugging once a database sink is enabled.
+		sink.Add(kafka.StdoutSink{}, *pgDSN == "")
+	}
+	if *enableDebug {
+		slog.Warn("Debug endpoints enabled, do not run like this in production")
+		http.HandleFunc("/debug/inject", injectHandler(c))
+	}
+	go func() {
+		log.Fatal(http.ListenAndServe(*addr, nil))
+	}()
+	if *pushgateway != "" {
+		// Shutdown hooks run in order, so this pushes what the sinks
+		// registered above counted while flushing.
+		stats.OnShutdown(func() { pushMetrics(*pushgateway, *pushJob) })
 	}
-	err = c.KafkaConsume()
+	err = c.KafkaConsume(ctx, sink)
 	if err != nil {
-		log.Println(err)
+		slog.Error("Consumer stopped", slog.Any("err", err))
 	}
+	stats.Shutdown("reader")
 }
@@ -0,0 +1,145 @@
+package parquetsink
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+// row is the Parquet schema of a stored reading.
+type row struct {
+	OBUID     int64     `parquet:"obuid"`
+	Lat       float64   `parquet:"lat"`
+	Lon       float64   `parquet:"lon"`
+	Timestamp time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	FwVersion string    `parquet:"fw_version,optional"`
+}
+
+// Sink accumulates readings and writes them as Parquet files under dir,
+// partitioned Hive-style by the reading's UTC date and hour, e.g.
+// dir/date=2024-05-01/hour=13/part-1714568400000000000.parquet. A flush
+// happens once maxRows readings are buffered, every interval, and on Close.
+type Sink struct {
+	dir      string
+	maxRows  int
+	interval time.Duration
+
+	rows chan types.SourceCoords
+	done chan struct{}
+	once sync.Once
+}
+
+// NewSink starts a sink writing below dir.
+func NewSink(dir string, maxRows int, interval time.Duration) (*Sink, error) {
+	if maxRows <= 0 {
+		return nil, fmt.Errorf("max rows %d is not positive", maxRows)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("flush interval %v is not positive", interval)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Sink{
+		dir:      dir,
+		maxRows:  maxRows,
+		interval: interval,
+		rows:     make(chan types.SourceCoords, maxRows),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Observe queues t. It blocks while the buffer is full rather than lose rows.
+func (s *Sink) Observe(t types.SourceCoords) {
+	s.rows <- t
+}
+
+// Close writes every buffered row and stops the sink.
+func (s *Sink) Close() {
+	s.once.Do(func() {
+		close(s.rows)
+		<-s.done
+	})
+}
+
+func (s *Sink) run() {
+	defer close(s.done)
+	pending := make(map[string][]row)
+	n := 0
+	flush := func() {
+		for part, rows := range pending {
+			if err := s.write(part, rows); err != nil {
+				slog.Error("Couldn't write Parquet rows", slog.Int("rows", len(rows)), slog.String("partition", part), slog.Any("err", err))
+			}
+		}
+		pending = make(map[string][]row)
+		n = 0
+	}
+	tick := time.NewTicker(s.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case t, ok := <-s.rows:
+			if !ok {
+				flush()
+				return
+			}
+			ts := t.Timestamp
+			if ts.IsZero() {
+				ts = time.Now()
+			}
+			ts = ts.UTC()
+			part := filepath.Join("date="+ts.Format("2006-01-02"), fmt.Sprintf("hour=%02d", ts.Hour()))
+			pending[part] = append(pending[part], row{
+				OBUID:     int64(t.OBUID),
+				Lat:       t.Lat,
+				Lon:       t.Lon,
+				Timestamp: ts,
+				FwVersion: t.FwVersion,
+			})
+			n++
+			if n >= s.maxRows {
+				flush()
+			}
+		case <-tick.C:
+			flush()
+		}
+	}
+}
+
+// write stores rows as a new file in part. The file is written under a
+// temporary name and renamed, so readers never pick up a partial file.
+func (s *Sink) write(part string, rows []row) error {
+	dir := filepath.Join(s.dir, part)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("part-%d.parquet", time.Now().UnixNano()))
+	f, err := os.Create(name + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	w := parquet.NewGenericWriter[row](f)
+	if _, err := w.Write(rows); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
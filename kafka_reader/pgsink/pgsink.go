@@ -0,0 +1,220 @@
+// Package pgsink stores readings in PostgreSQL with PostGIS, in a table like
+//
+//	CREATE TABLE positions (
+//		obuid     bigint           NOT NULL,
+//		lat       double precision NOT NULL,
+//		lon       double precision NOT NULL,
+//		timestamp timestamptz      NOT NULL,
+//		geog      geography(Point, 4326) NOT NULL
+//	);
+package pgsink
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+const maxAttempts = 5
+
+// maxBatch keeps an insert under PostgreSQL's limit of 65535 bind
+// parameters, four per row.
+const maxBatch = 16000
+
+// Sink batches readings into multi-row inserts, written from its own
+// goroutine. A batch is inserted once it holds batchSize readings, every
+// interval, and on Close. It is a kafka.BatchSink: every reading is
+// acknowledged once the batch holding it has been inserted, so KafkaConsume
+// never commits a reading that isn't stored.
+type Sink struct {
+	db        *sql.DB
+	table     string
+	batchSize int
+	interval  time.Duration
+
+	rows chan pending
+	done chan struct{}
+	once sync.Once
+}
+
+// pending is a queued reading and what to tell whether it was inserted.
+type pending struct {
+	t    types.SourceCoords
+	done func(error)
+}
+
+// NewSink connects to the database at dsn, for example
+// postgres://gps:secret@db:5432/fleet?sslmode=disable, and starts a sink
+// inserting into table.
+func NewSink(dsn, table string, batchSize int, interval time.Duration) (*Sink, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connecting to postgres: %w", err)
+	}
+	s, err := newSink(db, table, batchSize, interval)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func newSink(db *sql.DB, table string, batchSize int, interval time.Duration) (*Sink, error) {
+	if batchSize <= 0 || batchSize > maxBatch {
+		return nil, fmt.Errorf("batch size %d outside 1..%d", batchSize, maxBatch)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("flush interval %v is not positive", interval)
+	}
+	s := &Sink{
+		db:        db,
+		table:     pq.QuoteIdentifier(table),
+		batchSize: batchSize,
+		interval:  interval,
+		rows:      make(chan pending, batchSize),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Write inserts t, returning once it is stored or the insert has failed for
+// good. Readings without a timestamp are stored with the time they were
+// written.
+func (s *Sink) Write(ctx context.Context, t types.SourceCoords) error {
+	errc := make(chan error, 1)
+	s.WriteAsync(ctx, t, func(err error) { errc <- err })
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WriteAsync queues t for the next batch, blocking while the queue is full
+// rather than lose it, and calls done once the batch has been inserted or has
+// failed for good. Readings without a timestamp are stored with the time they
+// were queued.
+func (s *Sink) WriteAsync(ctx context.Context, t types.SourceCoords, done func(error)) {
+	if t.Timestamp.IsZero() {
+		t.Timestamp = time.Now()
+	}
+	select {
+	case s.rows <- pending{t: t, done: done}:
+	case <-ctx.Done():
+		done(ctx.Err())
+	}
+}
+
+// Close inserts every queued reading, stops the sink and closes the
+// database.
+func (s *Sink) Close() {
+	s.once.Do(func() {
+		close(s.rows)
+		<-s.done
+		s.db.Close()
+	})
+}
+
+func (s *Sink) run() {
+	defer close(s.done)
+	batch := make([]pending, 0, s.batchSize)
+	tick := time.NewTicker(s.interval)
+	defer tick.Stop()
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := s.flush(batch)
+		for _, p := range batch {
+			p.done(err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case p, ok := <-s.rows:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, p)
+			if len(batch) >= s.batchSize {
+				flush()
+			}
+		case <-tick.C:
+			flush()
+		}
+	}
+}
+
+// flush inserts batch, retrying transient errors with backoff.
+func (s *Sink) flush(batch []pending) error {
+	query, args := s.insert(batch)
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		_, err := s.db.ExecContext(ctx, query, args...)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if !transient(err) || attempt == maxAttempts {
+			return fmt.Errorf("inserting %d readings failed after %d attempts: %w", len(batch), attempt, err)
+		}
+		slog.Warn("Postgres insert failed, retrying", slog.Int("readings", len(batch)),
+			slog.Int("attempt", attempt), slog.Any("err", err))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// insert builds a single statement inserting every reading in batch.
+func (s *Sink) insert(batch []pending) (string, []any) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s (obuid, lat, lon, timestamp, geog) VALUES ", s.table)
+	args := make([]any, 0, 4*len(batch))
+	for i, p := range batch {
+		t := p.t
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		n := 4 * i
+		fmt.Fprintf(&b, "($%d, $%d, $%d, $%d, ST_SetSRID(ST_MakePoint($%d, $%d), 4326)::geography)",
+			n+1, n+2, n+3, n+4, n+3, n+2)
+		args = append(args, t.OBUID, t.Lat, t.Lon, t.Timestamp)
+	}
+	return b.String(), args
+}
+
+// transient reports whether err is worth retrying: anything that isn't a
+// server error, such as a lost connection or a timeout, and server errors in
+// the connection exception, transaction rollback, insufficient resources and
+// operator intervention classes.
+func transient(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return true
+	}
+	switch pqErr.Code.Class() {
+	case "08", "40", "53", "57":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package pgsink
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+func TestBatchInsertedAfterInterval(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const interval = 100 * time.Millisecond
+	t0 := time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)
+	readings := []types.SourceCoords{
+		{OBUID: 1, Lat: 48.85, Lon: 2.29, Timestamp: t0},
+		{OBUID: 2, Lat: 51.5, Lon: -0.12, Timestamp: t0.Add(time.Second)},
+	}
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "positions" (obuid, lat, lon, timestamp, geog) VALUES `+
+		`($1, $2, $3, $4, ST_SetSRID(ST_MakePoint($3, $2), 4326)::geography), `+
+		`($5, $6, $7, $8, ST_SetSRID(ST_MakePoint($7, $6), 4326)::geography)`)).
+		WithArgs(1, 48.85, 2.29, t0, 2, 51.5, -0.12, t0.Add(time.Second)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectClose()
+
+	// The batch is far from full, so only the interval can flush it.
+	s, err := newSink(db, "positions", 100, interval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	acks := make(chan error, len(readings))
+	for _, r := range readings {
+		s.WriteAsync(context.Background(), r, func(err error) { acks <- err })
+	}
+	for range readings {
+		select {
+		case err := <-acks:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("batch not inserted")
+		}
+	}
+	if took := time.Since(start); took < interval/2 {
+		t.Errorf("batch inserted after %v, before the %v flush interval", took, interval)
+	}
+	s.Close()
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewSinkValidates(t *testing.T) {
+	tests := []struct {
+		name      string
+		batchSize int
+		interval  time.Duration
+	}{
+		{"zero batch", 0, time.Second},
+		{"batch over the bind limit", maxBatch + 1, time.Second},
+		{"zero interval", 10, 0},
+		{"negative interval", 10, -time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if _, err := newSink(db, "positions", tt.batchSize, tt.interval); err == nil {
+				t.Error("newSink accepted it")
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package positions
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type featureCollection struct {
+	Type     string    `json:"type"`
+	Features []feature `json:"features"`
+}
+
+type feature struct {
+	Type       string            `json:"type"`
+	Geometry   point             `json:"geometry"`
+	Properties featureProperties `json:"properties"`
+}
+
+type point struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // GeoJSON order: lon, lat
+}
+
+type featureProperties struct {
+	OBUID     int        `json:"obuid"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+}
+
+// GeoJSON returns every latest position as a GeoJSON FeatureCollection of
+// points, ordered by OBUID.
+func (s *Store) GeoJSON() ([]byte, error) {
+	snap := s.Snapshot()
+	sort.Slice(snap, func(i, j int) bool { return snap[i].OBUID < snap[j].OBUID })
+	fc := featureCollection{Type: "FeatureCollection", Features: make([]feature, 0, len(snap))}
+	for _, t := range snap {
+		f := feature{
+			Type:       "Feature",
+			Geometry:   point{Type: "Point", Coordinates: [2]float64{t.Lon, t.Lat}},
+			Properties: featureProperties{OBUID: t.OBUID},
+		}
+		if !t.Timestamp.IsZero() {
+			ts := t.Timestamp
+			f.Properties.Timestamp = &ts
+		}
+		fc.Features = append(fc.Features, f)
+	}
+	return json.Marshal(fc)
+}
+
+// ServeGeoJSON serves GET /snapshot.geojson.
+func (s *Store) ServeGeoJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	b, err := s.GeoJSON()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Write(b)
+}
+
+// WriteGeoJSONEvery writes a snapshot to path at every interval. Each file is
+// written beside path and renamed into place, so readers never see a
+// partial snapshot.
+func (s *Store) WriteGeoJSONEvery(path string, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := s.writeGeoJSON(path); err != nil {
+			slog.Error("Couldn't write GeoJSON snapshot", slog.String("path", path), slog.Any("err", err))
+		}
+	}
+}
+
+func (s *Store) writeGeoJSON(path string) error {
+	b, err := s.GeoJSON()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,43 @@
+package positions
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ServePosition serves GET /position/{obuid}, the OBU's latest reading, or
+// 404 if it hasn't been seen.
+func (s *Store) ServePosition(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/position/"))
+	if err != nil {
+		http.Error(w, "invalid OBU id", http.StatusBadRequest)
+		return
+	}
+	t, ok := s.Get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// ServePositions serves GET /positions, the latest reading of every OBU seen,
+// ordered by OBUID.
+func (s *Store) ServePositions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	snap := s.Snapshot()
+	sort.Slice(snap, func(i, j int) bool { return snap[i].OBUID < snap[j].OBUID })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snap)
+}
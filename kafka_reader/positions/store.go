@@ -0,0 +1,48 @@
+package positions
+
+import (
+	"sync"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+// Store keeps the latest reading of every OBU seen.
+type Store struct {
+	mu     sync.RWMutex
+	latest map[int]types.SourceCoords
+}
+
+func NewStore() *Store {
+	return &Store{latest: make(map[int]types.SourceCoords)}
+}
+
+// Observe records t as its OBU's latest position. A reading older than the
+// one already stored is ignored.
+func (s *Store) Observe(t types.SourceCoords) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := s.latest[t.OBUID]; ok && t.Timestamp.Before(cur.Timestamp) {
+		return
+	}
+	s.latest[t.OBUID] = t
+}
+
+// Snapshot returns a copy of every latest position, taken under one read
+// lock so it reflects a single moment.
+func (s *Store) Snapshot() []types.SourceCoords {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]types.SourceCoords, 0, len(s.latest))
+	for _, t := range s.latest {
+		out = append(out, t)
+	}
+	return out
+}
+
+// Get returns obuid's latest position, if it has been seen.
+func (s *Store) Get(obuid int) (types.SourceCoords, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.latest[obuid]
+	return t, ok
+}
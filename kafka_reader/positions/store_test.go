@@ -0,0 +1,117 @@
+package positions
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+func TestStoreKeepsLatest(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		observed []types.SourceCoords
+		want     types.SourceCoords
+	}{
+		{"single reading", []types.SourceCoords{{OBUID: 1, Lat: 1, Timestamp: t0}}, types.SourceCoords{OBUID: 1, Lat: 1, Timestamp: t0}},
+		{"newer replaces", []types.SourceCoords{
+			{OBUID: 1, Lat: 1, Timestamp: t0},
+			{OBUID: 1, Lat: 2, Timestamp: t0.Add(time.Second)},
+		}, types.SourceCoords{OBUID: 1, Lat: 2, Timestamp: t0.Add(time.Second)}},
+		{"older ignored", []types.SourceCoords{
+			{OBUID: 1, Lat: 2, Timestamp: t0.Add(time.Second)},
+			{OBUID: 1, Lat: 1, Timestamp: t0},
+		}, types.SourceCoords{OBUID: 1, Lat: 2, Timestamp: t0.Add(time.Second)}},
+		{"same time replaces", []types.SourceCoords{
+			{OBUID: 1, Lat: 1, Timestamp: t0},
+			{OBUID: 1, Lat: 2, Timestamp: t0},
+		}, types.SourceCoords{OBUID: 1, Lat: 2, Timestamp: t0}},
+		{"other OBU untouched", []types.SourceCoords{
+			{OBUID: 1, Lat: 1, Timestamp: t0},
+			{OBUID: 2, Lat: 9, Timestamp: t0.Add(time.Hour)},
+		}, types.SourceCoords{OBUID: 1, Lat: 1, Timestamp: t0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStore()
+			for _, r := range tt.observed {
+				s.Observe(r)
+			}
+			got, ok := s.Get(tt.want.OBUID)
+			if !ok || got != tt.want {
+				t.Errorf("Get(%d) = %+v, %v, want %+v", tt.want.OBUID, got, ok, tt.want)
+			}
+		})
+	}
+	if _, ok := NewStore().Get(1); ok {
+		t.Error("Get on an empty store found a position")
+	}
+}
+
+func TestServePosition(t *testing.T) {
+	s := NewStore()
+	seen := types.SourceCoords{OBUID: 7, Lat: 48.85, Lon: 2.29, Timestamp: time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)}
+	s.Observe(seen)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{"seen", http.MethodGet, "/position/7", http.StatusOK},
+		{"unseen", http.MethodGet, "/position/8", http.StatusNotFound},
+		{"not a number", http.MethodGet, "/position/abc", http.StatusBadRequest},
+		{"missing id", http.MethodGet, "/position/", http.StatusBadRequest},
+		{"wrong method", http.MethodPost, "/position/7", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServePosition(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type %q, want application/json", ct)
+			}
+			var got types.SourceCoords
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !got.Timestamp.Equal(seen.Timestamp) || got.OBUID != seen.OBUID || got.Lat != seen.Lat || got.Lon != seen.Lon {
+				t.Errorf("served %+v, want %+v", got, seen)
+			}
+		})
+	}
+}
+
+func TestServePositions(t *testing.T) {
+	s := NewStore()
+	for _, id := range []int{3, 1, 2} {
+		s.Observe(types.SourceCoords{OBUID: id, Lat: float64(id)})
+	}
+	w := httptest.NewRecorder()
+	s.ServePositions(w, httptest.NewRequest(http.MethodGet, "/positions", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+	var got []types.SourceCoords
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("served %d positions, want 3", len(got))
+	}
+	for i, r := range got {
+		if r.OBUID != i+1 || r.Lat != float64(i+1) {
+			t.Errorf("position %d = %+v, want OBU %d ordered by OBUID", i, r, i+1)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// pushMetrics sends the final state of the default registry to a Prometheus
+// Pushgateway, for runs that end before they are scraped.
+func pushMetrics(url, job string) {
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "unknown"
+	}
+	err = push.New(url, job).
+		Gatherer(prometheus.DefaultGatherer).
+		Grouping("instance", instance).
+		Push()
+	if err != nil {
+		slog.Error("Couldn't push metrics", slog.String("url", url), slog.Any("err", err))
+		return
+	}
+	slog.Info("Pushed metrics", slog.String("url", url))
+}
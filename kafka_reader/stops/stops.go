@@ -0,0 +1,103 @@
+package stops
+
+import (
+	"sync"
+	"time"
+
+	"github.com/erastusk/gpscords/geo"
+	"github.com/erastusk/gpscords/types"
+)
+
+// Event types.
+const (
+	EventStart = "start"
+	EventEnd   = "end"
+)
+
+// exitConfirm is how many consecutive readings must fall outside the radius
+// before a stop is considered over, so a single jittery fix doesn't split
+// one stop into two.
+const exitConfirm = 2
+
+// Event reports the start or end of a stop. Duration is how long the OBU had
+// been stopped at the time of the event.
+type Event struct {
+	Type     string        `json:"type"`
+	OBUID    int           `json:"obuid"`
+	Lat      float64       `json:"lat"`
+	Lon      float64       `json:"lon"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration"`
+}
+
+type cluster struct {
+	lat, lon float64 // running centroid of the readings in the cluster
+	n        int
+	start    time.Time
+	last     time.Time
+	stopped  bool
+	outside  int
+}
+
+// Detector finds periods where an OBU stays within radius meters for at least
+// minDuration.
+type Detector struct {
+	mu          sync.Mutex
+	radius      float64
+	minDuration time.Duration
+	emit        func(Event)
+	clusters    map[int]*cluster
+}
+
+// NewDetector returns a Detector that calls emit for every stop event.
+func NewDetector(radius float64, minDuration time.Duration, emit func(Event)) *Detector {
+	return &Detector{
+		radius:      radius,
+		minDuration: minDuration,
+		emit:        emit,
+		clusters:    make(map[int]*cluster),
+	}
+}
+
+// Observe feeds a consumed reading to the detector. The reading's timestamp
+// is used when present, otherwise the time it was consumed.
+func (d *Detector) Observe(t types.SourceCoords) {
+	at := t.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.clusters[t.OBUID]
+	if !ok {
+		d.clusters[t.OBUID] = newCluster(t, at)
+		return
+	}
+	if geo.Haversine(c.lat, c.lon, t.Lat, t.Lon) <= d.radius {
+		c.outside = 0
+		c.n++
+		c.lat += (t.Lat - c.lat) / float64(c.n)
+		c.lon += (t.Lon - c.lon) / float64(c.n)
+		c.last = at
+		if !c.stopped && at.Sub(c.start) >= d.minDuration {
+			c.stopped = true
+			d.emit(Event{Type: EventStart, OBUID: t.OBUID, Lat: c.lat, Lon: c.lon,
+				Start: c.start, Duration: at.Sub(c.start)})
+		}
+		return
+	}
+	c.outside++
+	if c.stopped && c.outside < exitConfirm {
+		return
+	}
+	if c.stopped {
+		d.emit(Event{Type: EventEnd, OBUID: t.OBUID, Lat: c.lat, Lon: c.lon,
+			Start: c.start, End: c.last, Duration: c.last.Sub(c.start)})
+	}
+	d.clusters[t.OBUID] = newCluster(t, at)
+}
+
+func newCluster(t types.SourceCoords, at time.Time) *cluster {
+	return &cluster{lat: t.Lat, lon: t.Lon, n: 1, start: at, last: at}
+}
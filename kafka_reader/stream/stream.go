@@ -0,0 +1,101 @@
+// Package stream fans consumed readings out to HTTP clients as Server-Sent
+// Events.
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+var dropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "stream_events_dropped_total",
+	Help: "Readings not sent to a /stream client because it fell behind.",
+})
+
+// Hub hands every reading to each connected client. Clients get their own
+// buffer; a client whose buffer is full misses readings rather than holding
+// up the consumer.
+type Hub struct {
+	buffer  int
+	mu      sync.Mutex
+	clients map[chan []byte]bool
+}
+
+// NewHub returns a Hub buffering up to buffer readings per client.
+func NewHub(buffer int) (*Hub, error) {
+	if buffer < 0 {
+		return nil, fmt.Errorf("stream buffer %d is negative", buffer)
+	}
+	return &Hub{buffer: buffer, clients: map[chan []byte]bool{}}, nil
+}
+
+// Observe sends t to every client. It never blocks.
+func (h *Hub) Observe(t types.SourceCoords) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		slog.Error("Couldn't marshal reading for stream", slog.Int("obuid", t.OBUID), slog.Any("err", err))
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		select {
+		case ch <- b:
+		default:
+			dropped.Inc()
+		}
+	}
+}
+
+func (h *Hub) subscribe() chan []byte {
+	ch := make(chan []byte, h.buffer)
+	h.mu.Lock()
+	h.clients[ch] = true
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *Hub) unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	delete(h.clients, ch)
+	h.mu.Unlock()
+}
+
+// ServeHTTP serves GET /stream, sending each reading as a JSON event until
+// the client goes away.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	ch := h.subscribe()
+	defer h.unsubscribe(ch)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case b := <-ch:
+			if _, err := fmt.Fprintf(w, "event: reading\ndata: %s\n\n", b); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
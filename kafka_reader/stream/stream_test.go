@@ -0,0 +1,73 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+func TestReadingArrivesAsEvent(t *testing.T) {
+	h, err := NewHub(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type %q, want text/event-stream", ct)
+	}
+
+	// The client is subscribed before the headers are sent.
+	want := types.SourceCoords{OBUID: 7, Lat: 48.85, Lon: 2.29}
+	h.Observe(want)
+	r := bufio.NewReader(resp.Body)
+	var frame []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "\n" {
+			break
+		}
+		frame = append(frame, strings.TrimSuffix(line, "\n"))
+	}
+	if len(frame) != 2 || frame[0] != "event: reading" || !strings.HasPrefix(frame[1], "data: ") {
+		t.Fatalf("got frame %q, want an event and a data line", frame)
+	}
+	var got types.SourceCoords
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(frame[1], "data: ")), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("event carried %+v, want %+v", got, want)
+	}
+}
+
+func TestStreamRejectsOtherMethods(t *testing.T) {
+	h, err := NewHub(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/stream", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestNegativeBufferRejected(t *testing.T) {
+	if _, err := NewHub(-1); err == nil {
+		t.Error("NewHub accepted a negative buffer")
+	}
+}
@@ -0,0 +1,33 @@
+// Package logging sets up the structured logger shared by the services.
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Setup installs the default slog logger, tagging every record with
+// service. LOG_LEVEL sets the minimum level: debug, info (the default), warn
+// or error. LOG_FORMAT picks the output: json, the default, or text for
+// reading locally. Output from the log package goes through the same
+// handler at info level.
+func Setup(service string) {
+	slog.SetDefault(New(os.Stderr, service, os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT")))
+}
+
+// New returns a logger writing to w. Unknown levels fall back to info and
+// unknown formats to json.
+func New(w io.Writer, service, level, format string) *slog.Logger {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		l = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	var h slog.Handler = slog.NewJSONHandler(w, opts)
+	if strings.EqualFold(format, "text") {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(h).With(slog.String("service", service))
+}
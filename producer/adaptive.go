@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+// aimd adapts the send rate to how the receiver copes, like TCP congestion
+// control: every healthy write adds step Hz, every failed or slow write
+// multiplies the rate by backoff. The rate stays within [min, max] Hz.
+type aimd struct {
+	mu      sync.Mutex
+	rate    float64
+	min     float64
+	max     float64
+	step    float64
+	backoff float64
+	slow    time.Duration
+}
+
+func newAIMD(start, min, max, step, backoff float64, slow time.Duration) *aimd {
+	return &aimd{
+		rate:    math.Max(min, math.Min(max, start)),
+		min:     min,
+		max:     max,
+		step:    step,
+		backoff: backoff,
+		slow:    slow,
+	}
+}
+
+// Observe feeds back the outcome of one write.
+func (a *aimd) Observe(latency time.Duration, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err == nil && latency < a.slow {
+		a.rate = math.Min(a.max, a.rate+a.step)
+		return
+	}
+	old := a.rate
+	a.rate = math.Max(a.min, a.rate*a.backoff)
+	slog.Warn("Receiver struggling, slowing down", slog.Duration("latency", latency), slog.Any("err", err),
+		slog.Float64("old_rate_hz", old), slog.Float64("rate_hz", a.rate))
+}
+
+// Rate returns the current send rate in Hz.
+func (a *aimd) Rate() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rate
+}
+
+// Interval returns the pause between readings at the current rate.
+func (a *aimd) Interval() time.Duration {
+	return time.Duration(float64(time.Second) / a.Rate())
+}
+
+// logEvery logs the current rate at a fixed interval.
+func (a *aimd) logEvery(d time.Duration) {
+	for range time.Tick(d) {
+		slog.Info("Adaptive send rate", slog.Float64("rate_hz", a.Rate()))
+	}
+}
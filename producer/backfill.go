@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/erastusk/gpscords/stats"
+	"github.com/erastusk/gpscords/types"
+)
+
+// backfill sends every reading in a JSON-lines track file as fast as the
+// connection allows. Readings keep the timestamp recorded in the file.
+func backfill(conn *websocket.Conn, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	for {
+		var t types.SourceCoords
+		err := dec.Decode(&t)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: reading %d: %w", path, stats.Produced.Load()+1, err)
+		}
+		if err := conn.WriteJSON(t); err != nil {
+			stats.Errors.Add(1)
+			return err
+		}
+		stats.Produced.Add(1)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"time"
+
+	"github.com/erastusk/gpscords/geo"
+	"github.com/erastusk/gpscords/types"
+)
+
+// dedup suppresses readings of OBUs that haven't moved. A reading within
+// epsilon meters of the last one sent for the same OBU is dropped, unless
+// keepalive has passed since then, so a parked OBU still reports
+// periodically.
+type dedup struct {
+	epsilon   float64
+	keepalive time.Duration
+	last      map[int]types.SourceCoords
+	sentAt    map[int]time.Time
+	pruned    time.Time
+}
+
+func newDedup(epsilon float64, keepalive time.Duration) *dedup {
+	return &dedup{
+		epsilon:   epsilon,
+		keepalive: keepalive,
+		last:      map[int]types.SourceCoords{},
+		sentAt:    map[int]time.Time{},
+	}
+}
+
+// Allow reports whether t should be sent, remembering it if so.
+func (d *dedup) Allow(t types.SourceCoords, now time.Time) bool {
+	d.prune(now)
+	prev, ok := d.last[t.OBUID]
+	if ok && now.Sub(d.sentAt[t.OBUID]) < d.keepalive &&
+		geo.Haversine(prev.Lat, prev.Lon, t.Lat, t.Lon) <= d.epsilon {
+		return false
+	}
+	d.last[t.OBUID] = t
+	d.sentAt[t.OBUID] = now
+	return true
+}
+
+// prune forgets OBUs not sent for longer than keepalive; their next reading
+// is let through anyway. It runs at most once per keepalive.
+func (d *dedup) prune(now time.Time) {
+	if now.Sub(d.pruned) < d.keepalive {
+		return
+	}
+	d.pruned = now
+	for id, at := range d.sentAt {
+		if now.Sub(at) >= d.keepalive {
+			delete(d.sentAt, id)
+			delete(d.last, id)
+		}
+	}
+}
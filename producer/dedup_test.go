@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+func TestDedupSendsFirstAndKeepalives(t *testing.T) {
+	d := newDedup(10, time.Minute)
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var sent []time.Duration
+	// A parked OBU jitters by about a meter every second for 150s.
+	for i := 0; i <= 150; i++ {
+		at := time.Duration(i) * time.Second
+		jitter := float64(i%3) * 0.00001
+		if d.Allow(types.SourceCoords{OBUID: 1, Lat: 48.85 + jitter, Lon: 2.29}, t0.Add(at)) {
+			sent = append(sent, at)
+		}
+	}
+	want := []time.Duration{0, time.Minute, 2 * time.Minute}
+	if len(sent) != len(want) {
+		t.Fatalf("sent at %v, want %v", sent, want)
+	}
+	for i := range want {
+		if sent[i] != want[i] {
+			t.Errorf("sent at %v, want %v", sent, want)
+			break
+		}
+	}
+}
+
+func TestDedupLetsMovesThrough(t *testing.T) {
+	d := newDedup(10, time.Minute)
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		t    types.SourceCoords
+		want bool
+	}{
+		{"first reading", types.SourceCoords{OBUID: 1, Lat: 48.85, Lon: 2.29}, true},
+		{"same place", types.SourceCoords{OBUID: 1, Lat: 48.85, Lon: 2.29}, false},
+		{"other OBU", types.SourceCoords{OBUID: 2, Lat: 48.85, Lon: 2.29}, true},
+		{"moved 110m", types.SourceCoords{OBUID: 1, Lat: 48.851, Lon: 2.29}, true},
+		{"within epsilon of the moved reading", types.SourceCoords{OBUID: 1, Lat: 48.85101, Lon: 2.29}, false},
+	}
+	for i, tt := range tests {
+		if got := d.Allow(tt.t, t0.Add(time.Duration(i)*time.Second)); got != tt.want {
+			t.Errorf("%s: Allow = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+// Fleet simulates OBUs 1 to Size as independent vehicles. Each runs on its
+// own goroutine with its own connection to the receiver, movement state and
+// send rate, so one slow or reconnecting OBU doesn't hold up the others.
+type Fleet struct {
+	Size int
+	// RateHz is the average rate at which each OBU sends readings. Every
+	// OBU's own rate is drawn within RateJitter, a fraction of it, so the
+	// fleet doesn't send in lockstep.
+	RateHz     float64
+	RateJitter float64
+	// MaxStep is the farthest an OBU moves between readings, in meters.
+	MaxStep float64
+	// Seed makes the fleet reproducible: OBU i draws from Seed+i.
+	Seed      int64
+	FwVersion string
+	// Seq and Spool are shared by every OBU and may be nil. DedupEps, when
+	// set, gives each OBU its own dedup with DedupKeep.
+	Seq       *sequencer
+	Spool     *spool
+	DedupEps  float64
+	DedupKeep time.Duration
+	// Retry is the schedule OBUs dial and redial the receiver with.
+	Retry backoff
+}
+
+// Run dials a connection per OBU and sends readings until ctx is done. It
+// fails if any OBU can't connect, closing the connections already open.
+func (f *Fleet) Run(ctx context.Context, endpoint string) error {
+	conns := make([]*websocket.Conn, 0, f.Size)
+	for i := 0; i < f.Size; i++ {
+		conn, err := f.Retry.dial(ctx, endpoint)
+		if err != nil {
+			for _, c := range conns {
+				closeConn(c)
+			}
+			return err
+		}
+		conns = append(conns, conn)
+	}
+	slog.Info("Fleet connected", slog.Int("obus", f.Size))
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(obuid int, conn *websocket.Conn) {
+			defer wg.Done()
+			f.emit(ctx, obuid, conn, endpoint)
+		}(i+1, conn)
+	}
+	wg.Wait()
+	return nil
+}
+
+// emit runs one OBU: a generator feeding its own queue, drained by the same
+// write loop the single-connection producer uses.
+func (f *Fleet) emit(ctx context.Context, obuid int, conn *websocket.Conn, endpoint string) {
+	rng := rand.New(rand.NewSource(f.Seed + int64(obuid)))
+	walk := newWalker(rng, f.MaxStep)
+	rate := f.RateHz * (1 + f.RateJitter*(2*rng.Float64()-1))
+	interval := time.Duration(float64(time.Second) / rate)
+	var dd *dedup
+	if f.DedupEps > 0 {
+		dd = newDedup(f.DedupEps, f.DedupKeep)
+	}
+	q := make(fifo, 100)
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+			}
+			lat, lon := walk.Step(obuid, true)
+			t := types.SourceCoords{OBUID: obuid, Lat: lat, Lon: lon, Timestamp: time.Now(), FwVersion: f.FwVersion}
+			if dd != nil && !dd.Allow(t, time.Now()) {
+				continue
+			}
+			if f.Seq != nil {
+				t.Seq = f.Seq.Next(obuid)
+			}
+			select {
+			case q <- t:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	writeLoop(ctx, conn, endpoint, f.Retry, q, nil, f.Spool, 1)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFleetSendsFromEveryOBU(t *testing.T) {
+	srv := newFlakyServer(serve)
+	defer srv.Close()
+	f := &Fleet{Size: 3, RateHz: 50, RateJitter: 0.2, MaxStep: 10, Seed: 1}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- f.Run(ctx, srv.URL()) }()
+
+	// Each OBU has a connection of its own.
+	conns := map[int]map[int]bool{}
+	timeout := time.After(5 * time.Second)
+	for len(conns) < f.Size {
+		select {
+		case r := <-srv.readings:
+			if r.t.OBUID < 1 || r.t.OBUID > f.Size {
+				t.Fatalf("reading from OBU %d, want 1 to %d", r.t.OBUID, f.Size)
+			}
+			if conns[r.t.OBUID] == nil {
+				conns[r.t.OBUID] = map[int]bool{}
+			}
+			conns[r.t.OBUID][r.dial] = true
+		case <-timeout:
+			t.Fatalf("only heard from %d of %d OBUs", len(conns), f.Size)
+		}
+	}
+	cancel()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	seen := map[int]int{}
+	for obuid, dials := range conns {
+		if len(dials) != 1 {
+			t.Errorf("OBU %d sent on %d connections, want 1", obuid, len(dials))
+		}
+		for dial := range dials {
+			if other, ok := seen[dial]; ok {
+				t.Errorf("OBUs %d and %d share a connection", other, obuid)
+			}
+			seen[dial] = obuid
+		}
+	}
+}
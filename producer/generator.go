@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+// obuSet cycles through a fixed fleet of OBUIDs, 1 to n. A nil set stands
+// for an unbounded fleet, a new random OBU for every reading.
+type obuSet struct {
+	n, next int
+}
+
+func newOBUSet(n int) *obuSet {
+	if n <= 0 {
+		return nil
+	}
+	return &obuSet{n: n}
+}
+
+// Next returns the OBUID of the next reading, drawing random ones from rng.
+func (s *obuSet) Next(rng *rand.Rand) int {
+	if s == nil {
+		return rng.Int()
+	}
+	s.next = s.next%s.n + 1
+	return s.next
+}
+
+// Generator produces the simulated readings. All its randomness comes from
+// one source, so generators created with the same seed and settings yield
+// the same OBUs and positions.
+type Generator struct {
+	rng  *rand.Rand
+	obus *obuSet
+	walk *walker
+	now  func() time.Time
+}
+
+// NewGenerator returns a Generator seeded with seed that cycles through
+// obuCount OBUs (0 for a new random OBU per reading), moving each by at most
+// maxStep meters per reading.
+func NewGenerator(seed int64, obuCount int, maxStep float64) *Generator {
+	rng := rand.New(rand.NewSource(seed))
+	return &Generator{
+		rng:  rng,
+		obus: newOBUSet(obuCount),
+		walk: newWalker(rng, maxStep),
+		now:  time.Now,
+	}
+}
+
+// Next returns the next reading, timestamped now.
+func (g *Generator) Next() types.SourceCoords {
+	id := g.obus.Next(g.rng)
+	lat, lon := g.walk.Step(id, g.obus != nil)
+	return types.SourceCoords{OBUID: id, Lat: lat, Lon: lon, Timestamp: g.now()}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGeneratorOBUCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		obuCount int
+		ticks    int
+		want     int
+	}{
+		{"one OBU", 1, 50, 1},
+		{"fleet of five", 5, 50, 5},
+		{"fewer ticks than OBUs", 5, 3, 3},
+		{"unbounded", 0, 50, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGenerator(1, tt.obuCount, 10)
+			seen := map[int]bool{}
+			for i := 0; i < tt.ticks; i++ {
+				id := g.Next().OBUID
+				if tt.obuCount > 0 && (id < 1 || id > tt.obuCount) {
+					t.Fatalf("OBUID %d outside 1 to %d", id, tt.obuCount)
+				}
+				seen[id] = true
+			}
+			if len(seen) != tt.want {
+				t.Errorf("got %d distinct OBUIDs over %d ticks, want %d", len(seen), tt.ticks, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneratorIsDeterministic(t *testing.T) {
+	a, b := NewGenerator(42, 3, 10), NewGenerator(42, 3, 10)
+	other := NewGenerator(43, 3, 10)
+	// Timestamps come from the clock, not the seed.
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, g := range []*Generator{a, b, other} {
+		g.now = func() time.Time { return t0 }
+	}
+	differs := false
+	for i := 0; i < 100; i++ {
+		ra, rb, ro := a.Next(), b.Next(), other.Next()
+		if ra != rb {
+			t.Fatalf("reading %d: %+v and %+v from the same seed", i, ra, rb)
+		}
+		differs = differs || ra != ro
+	}
+	if !differs {
+		t.Error("seeds 42 and 43 gave the same readings")
+	}
+}
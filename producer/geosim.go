@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/erastusk/gpscords/geo"
+	"github.com/erastusk/gpscords/stats"
+	"github.com/erastusk/gpscords/types"
+)
+
+// geoSim drives one OBU at constant speed along a fixed path, so the times at
+// which it crosses a circular fence are known in advance.
+type geoSim struct {
+	OBUID      int          `json:"obuid"`
+	SpeedMPS   float64      `json:"speed_mps"`
+	IntervalMS int          `json:"interval_ms"`
+	Path       [][2]float64 `json:"path"` // [lat, lon] waypoints
+	Fence      struct {
+		Lat    float64 `json:"lat"`
+		Lon    float64 `json:"lon"`
+		Radius float64 `json:"radius"` // meters
+	} `json:"fence"`
+}
+
+// crossing is the moment the OBU enters or exits the fence, measured from
+// the start of the run. Tick is the first emitted reading on the new side.
+type crossing struct {
+	Type string
+	At   time.Duration
+	Tick int
+}
+
+func loadGeoSim(path string) (*geoSim, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var g geoSim
+	if err := json.Unmarshal(b, &g); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	switch {
+	case len(g.Path) < 2:
+		return nil, errors.New("geofence simulation needs at least two waypoints")
+	case g.SpeedMPS <= 0 || g.IntervalMS <= 0 || g.Fence.Radius <= 0:
+		return nil, errors.New("geofence simulation needs a positive speed, interval and fence radius")
+	}
+	return &g, nil
+}
+
+func (g *geoSim) interval() time.Duration {
+	return time.Duration(g.IntervalMS) * time.Millisecond
+}
+
+func segmentLength(a, b [2]float64) float64 {
+	return geo.Haversine(a[0], a[1], b[0], b[1])
+}
+
+func (g *geoSim) length() float64 {
+	var l float64
+	for i := 1; i < len(g.Path); i++ {
+		l += segmentLength(g.Path[i-1], g.Path[i])
+	}
+	return l
+}
+
+// position returns the point s meters along the path, interpolating linearly
+// between waypoints.
+func (g *geoSim) position(s float64) (float64, float64) {
+	for i := 1; i < len(g.Path); i++ {
+		a, b := g.Path[i-1], g.Path[i]
+		l := segmentLength(a, b)
+		if s <= l && l > 0 {
+			f := s / l
+			return a[0] + (b[0]-a[0])*f, a[1] + (b[1]-a[1])*f
+		}
+		s -= l
+	}
+	last := g.Path[len(g.Path)-1]
+	return last[0], last[1]
+}
+
+// inside reports whether the point s meters along the path is in the fence.
+// Points exactly on the boundary count as inside.
+func (g *geoSim) inside(s float64) bool {
+	lat, lon := g.position(s)
+	return geo.Haversine(lat, lon, g.Fence.Lat, g.Fence.Lon) <= g.Fence.Radius
+}
+
+// crossings walks the path in one meter steps and bisects every change of
+// side down to the centimeter.
+func (g *geoSim) crossings() []crossing {
+	var out []crossing
+	total := g.length()
+	prev := g.inside(0)
+	for s := 1.0; s < total+1; s++ {
+		s = math.Min(s, total)
+		cur := g.inside(s)
+		if cur != prev {
+			lo, hi := s-1, s
+			for hi-lo > 0.01 {
+				mid := (lo + hi) / 2
+				if g.inside(mid) == prev {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			at := time.Duration(hi / g.SpeedMPS * float64(time.Second))
+			c := crossing{Type: "exit", At: at, Tick: int(math.Ceil(float64(at) / float64(g.interval())))}
+			if cur {
+				c.Type = "enter"
+			}
+			out = append(out, c)
+			prev = cur
+		}
+		if s == total {
+			break
+		}
+	}
+	return out
+}
+
+// run emits one reading per interval until the end of the path is reached.
+func (g *geoSim) run(conn *websocket.Conn) error {
+	for _, c := range g.crossings() {
+		slog.Info("Geofence simulation", slog.String("type", c.Type), slog.Duration("at", c.At), slog.Int("reading", c.Tick))
+	}
+	total := g.length()
+	start := time.Now()
+	for tick := 0; ; tick++ {
+		elapsed := time.Duration(tick) * g.interval()
+		s := math.Min(g.SpeedMPS*elapsed.Seconds(), total)
+		lat, lon := g.position(s)
+		t := types.SourceCoords{OBUID: g.OBUID, Lat: lat, Lon: lon, Timestamp: start.Add(elapsed)}
+		if err := conn.WriteJSON(t); err != nil {
+			stats.Errors.Add(1)
+			return err
+		}
+		stats.Produced.Add(1)
+		if s == total {
+			return nil
+		}
+		time.Sleep(time.Until(start.Add(elapsed + g.interval())))
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erastusk/gpscords/geo"
+)
+
+func TestGeoSimCrossings(t *testing.T) {
+	g := &geoSim{OBUID: 1, SpeedMPS: 100, IntervalMS: 1000, Path: [][2]float64{{0, 0}, {0, 0.02}}}
+	g.Fence.Lat, g.Fence.Lon, g.Fence.Radius = 0, 0.01, 500
+	// The path runs due east through the fence's center.
+	center := geo.Haversine(0, 0, 0, 0.01)
+	want := []struct {
+		typ string
+		at  time.Duration
+	}{
+		{"enter", time.Duration((center - 500) / 100 * float64(time.Second))},
+		{"exit", time.Duration((center + 500) / 100 * float64(time.Second))},
+	}
+
+	got := g.crossings()
+	if len(got) != len(want) {
+		t.Fatalf("got %d crossings %+v, want %d", len(got), got, len(want))
+	}
+	for i, w := range want {
+		c := got[i]
+		if c.Type != w.typ {
+			t.Errorf("crossing %d is an %s, want %s", i, c.Type, w.typ)
+		}
+		if d := c.At - w.at; d < 0 || d > time.Millisecond {
+			t.Errorf("%s at %v, want %v", c.Type, c.At, w.at)
+		}
+		// The reading at Tick is the first on the new side.
+		reading := func(tick int) float64 { return g.SpeedMPS * (time.Duration(tick) * g.interval()).Seconds() }
+		entering := c.Type == "enter"
+		if g.inside(reading(c.Tick)) != entering || g.inside(reading(c.Tick-1)) == entering {
+			t.Errorf("%s at reading %d, but the readings around it don't change side there", c.Type, c.Tick)
+		}
+	}
+}
@@ -1,39 +1,326 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"flag"
 	"log"
-	"math/rand"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
 
+	"github.com/erastusk/gpscords/config"
+	"github.com/erastusk/gpscords/logging"
+	"github.com/erastusk/gpscords/stats"
 	"github.com/erastusk/gpscords/types"
 )
 
-func retOBUdata() (int, float64, float64) {
-	return rand.Int(), rand.Float64()*99 + 1, rand.Float64()*99 + 1
-}
-
-var wsEndpoint = "ws://localhost:30000/ws"
+var (
+	wsEndpoint   = flag.String("endpoint", "ws://localhost:30000/ws", "WebSocket URL of the receiver")
+	coalesce     = flag.Bool("coalesce", false, "only send the latest pending reading per OBU when the writer falls behind")
+	heartbeat    = flag.Duration("heartbeat", 0, "emit a heartbeat reading at this interval (0 disables)")
+	heartbeatID  = flag.Int("heartbeat-obuid", types.DefaultHeartbeatOBUID, "sentinel OBUID carried by heartbeat readings")
+	geoSimPath   = flag.String("geofence-sim", "", "drive one OBU along the path in this JSON config, across a known fence, then exit")
+	backfillPath = flag.String("backfill", "", "send every reading in this JSON-lines track file as fast as possible, keeping its timestamps, then exit")
+	adaptive     = flag.Bool("adaptive", false, "adapt the send rate to write latency and errors (AIMD)")
+	adaptMin     = flag.Float64("adaptive-min-hz", 0.1, "lowest adaptive send rate")
+	adaptMax     = flag.Float64("adaptive-max-hz", 50, "highest adaptive send rate")
+	adaptStep    = flag.Float64("adaptive-step", 0.1, "Hz added after each healthy write")
+	adaptBackoff = flag.Float64("adaptive-backoff", 0.5, "factor applied to the rate after a failed or slow write")
+	adaptSlow    = flag.Duration("adaptive-slow", 200*time.Millisecond, "writes taking at least this long count as slow")
+	duration     = flag.Duration("duration", 0, "stop after running this long (0 runs until interrupted)")
+	seqOn        = flag.Bool("seq", false, "stamp readings with per-OBU sequence numbers")
+	seqState     = flag.String("seq-state", "", "persist sequence numbers to this file and resume from it on restart (implies -seq)")
+	fwVersion    = flag.String("fw-version", "", "firmware version stamped on every reading")
+	spoolPath    = flag.String("spool", "", "append readings that couldn't be sent to this JSON-lines file")
+	spoolMax     = flag.Int64("spool-max-bytes", 10<<20, "maximum size of the spool file")
+	spoolReplay  = flag.Bool("spool-replay", false, "resend spooled readings once connected")
+	retryMin     = flag.Duration("reconnect-min", 100*time.Millisecond, "delay before the first redial of the receiver")
+	retryMax     = flag.Duration("reconnect-max", 30*time.Second, "longest delay between redials, which double after each failure")
+	retryTimes   = flag.Int("reconnect-attempts", 0, "give up after this many failed dials in a row (0 retries forever)")
+	dedupEps     = flag.Float64("dedup-epsilon", 0, "drop readings within this many meters of the OBU's last sent reading (0 disables)")
+	dedupKeep    = flag.Duration("dedup-keepalive", time.Minute, "send an unmoved OBU's reading at least this often despite -dedup-epsilon")
+	rateHz       = flag.Float64("rate-hz", 1, "readings generated per second")
+	obuCount     = flag.Int("obu-count", 0, "simulate a fleet of this many OBUs, numbered from 1, in turn (0 makes every reading a new random OBU)")
+	arrayMode    = flag.Bool("array", false, "each tick, generate a reading for every OBU of -obu-count and send them as one JSON array")
+	fleetSize    = flag.Int("fleet", 0, "simulate this many OBUs, each on its own connection and at its own rate around -rate-hz (0 sends from a single connection)")
+	fleetJitter  = flag.Float64("fleet-rate-jitter", 0.2, "spread of each -fleet OBU's rate, as a fraction of -rate-hz")
+	maxStep      = flag.Float64("max-step", 50, "farthest an OBU moves between readings, in meters")
+)
 
 func main() {
-	conn, _, err := websocket.DefaultDialer.Dial(wsEndpoint, nil)
+	logging.Setup("producer")
+	config.MustLoad("PRODUCER")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	retry := backoff{min: *retryMin, max: *retryMax, attempts: *retryTimes}
+	conn, err := retry.dial(ctx, *wsEndpoint)
 	if err != nil {
-		log.Println("Couldn't dial", err)
+		log.Fatal(err)
+	}
+	if *backfillPath != "" || *geoSimPath != "" {
+		// These modes end by themselves; a signal just reports and exits.
+		stop()
+		stats.ReportOnSignal("producer")
+	}
+	if *backfillPath != "" {
+		if err := backfill(conn, *backfillPath); err != nil {
+			slog.Error("Backfill failed", slog.Any("err", err))
+		}
+		closeConn(conn)
+		stats.Shutdown("producer")
+		return
+	}
+	if *geoSimPath != "" {
+		g, err := loadGeoSim(*geoSimPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := g.run(conn); err != nil {
+			slog.Error("Geofence simulation failed", slog.Any("err", err))
+		}
+		closeConn(conn)
+		stats.Shutdown("producer")
+		return
+	}
+	var seq *sequencer
+	if *seqOn || *seqState != "" {
+		seq = newSequencer(*seqState)
+		save := func() {
+			if err := seq.Save(); err != nil {
+				slog.Error("Couldn't save sequence state", slog.Any("err", err))
+			}
+		}
+		stats.OnShutdown(save)
+		go func() {
+			for range time.Tick(5 * time.Second) {
+				save()
+			}
+		}()
+	}
+	var sp *spool
+	if *spoolPath != "" {
+		sp = newSpool(*spoolPath, *spoolMax)
+		if *spoolReplay {
+			replay(conn, sp)
+		}
+	}
+	if *rateHz <= 0 {
+		log.Fatal("-rate-hz must be positive")
+	}
+	// perTick readings are generated each tick and up to that many are sent
+	// per write.
+	perTick := 1
+	if *arrayMode {
+		if *obuCount <= 0 {
+			log.Fatal("-array needs -obu-count")
+		}
+		perTick = *obuCount
+	}
+	interval := func() time.Duration { return time.Duration(float64(time.Second) / *rateHz) }
+	var rate *aimd
+	if *adaptive {
+		rate = newAIMD(*rateHz, *adaptMin, *adaptMax, *adaptStep, *adaptBackoff, *adaptSlow)
+		interval = rate.Interval
+		go rate.logEvery(30 * time.Second)
+	}
+	var q queue = make(fifo, 1000)
+	if *coalesce {
+		q = newCoalescer()
+	}
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	if *fleetSize > 0 {
+		if *fleetJitter < 0 || *fleetJitter >= 1 {
+			log.Fatal("-fleet-rate-jitter must be in [0, 1)")
+		}
+		// Every OBU dials its own connection.
+		closeConn(conn)
+		f := &Fleet{
+			Size:       *fleetSize,
+			RateHz:     *rateHz,
+			RateJitter: *fleetJitter,
+			MaxStep:    *maxStep,
+			Seed:       time.Now().UnixNano(),
+			FwVersion:  *fwVersion,
+			Seq:        seq,
+			Spool:      sp,
+			DedupEps:   *dedupEps,
+			DedupKeep:  *dedupKeep,
+			Retry:      retry,
+		}
+		if err := f.Run(ctx, *wsEndpoint); err != nil && ctx.Err() == nil {
+			log.Fatal(err)
+		}
+		stats.Shutdown("producer")
+		return
+	}
+	if *heartbeat > 0 {
+		go func() {
+			tick := time.NewTicker(*heartbeat)
+			defer tick.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-tick.C:
+					q.Push(types.SourceCoords{OBUID: *heartbeatID, Timestamp: time.Now()})
+				}
+			}
+		}()
+	}
+	var dd *dedup
+	if *dedupEps > 0 {
+		dd = newDedup(*dedupEps, *dedupKeep)
+	}
+	gen := NewGenerator(time.Now().UnixNano(), *obuCount, *maxStep)
+	next := Chain(gen.Next, Timing)
+	go func() {
+		timer := time.NewTimer(interval())
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+				timer.Reset(interval())
+			}
+			for i := 0; i < perTick; i++ {
+				t := next()
+				t.FwVersion = *fwVersion
+				if dd != nil && !dd.Allow(t, time.Now()) {
+					continue
+				}
+				if seq != nil {
+					t.Seq = seq.Next(t.OBUID)
+				}
+				q.Push(t)
+			}
+		}
+	}()
+	writeLoop(ctx, conn, *wsEndpoint, retry, q, rate, sp, perTick)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		slog.Info("Run duration reached, stopping")
 	}
+	stats.Shutdown("producer")
+}
+
+// writeLoop sends queued readings until ctx is done, redialing endpoint with
+// a schedule of retry whenever the connection drops. Readings already waiting
+// are sent together, up to max per write, as a JSON array. It closes the
+// connection before returning.
+func writeLoop(ctx context.Context, conn *websocket.Conn, endpoint string, retry backoff, q queue, rate *aimd, sp *spool, max int) {
 	for {
-		a, b, c := MiddlewareReceiver(retOBUdata)
-		t := types.SourceCoords{
-			OBUID: a,
-			Lat:   b,
-			Lon:   c,
-		}
-		time.Sleep(time.Second)
-		fmt.Printf("Producer: %+v\n", t)
-		err = conn.WriteJSON(t)
+		t, ok := q.Pop(ctx)
+		if !ok {
+			closeConn(conn)
+			return
+		}
+		batch := []types.SourceCoords{t}
+		for len(batch) < max {
+			t, ok := q.TryPop()
+			if !ok {
+				break
+			}
+			batch = append(batch, t)
+		}
+		var err error
+		if len(batch) == 1 {
+			err = send(conn, batch[0], rate, sp)
+		} else {
+			err = sendArray(conn, batch, rate, sp)
+		}
+		if err == nil {
+			continue
+		}
+		// The queue keeps filling while we redial, so nothing generated in
+		// the meantime is lost.
+		slog.Warn("Connection lost, reconnecting", slog.Any("err", err))
+		conn.Close()
+		conn, err = retry.dial(ctx, endpoint)
+		if ctx.Err() != nil {
+			return
+		}
 		if err != nil {
-			log.Println("Unable to write message")
+			log.Fatal(err)
 		}
+		stats.Reconnects.Add(1)
+		if sp != nil && *spoolReplay {
+			replay(conn, sp)
+		}
+	}
+}
+
+// replay resends the readings spooled while the receiver was unreachable.
+func replay(conn *websocket.Conn, sp *spool) {
+	n, err := sp.Replay(conn)
+	if err != nil {
+		slog.Error("Spool replay stopped early", slog.Any("err", err))
+	}
+	stats.Produced.Add(int64(n))
+	slog.Info("Replayed spooled readings", slog.Int("count", n))
+}
+
+// send writes one reading, feeding the outcome back to the adaptive rate and
+// spooling it if the write fails. rate and sp may be nil.
+func send(conn *websocket.Conn, t types.SourceCoords, rate *aimd, sp *spool) error {
+	slog.Info("Sending reading", slog.Int("obuid", t.OBUID),
+		slog.Float64("lat", t.Lat), slog.Float64("lon", t.Lon), slog.Uint64("seq", t.Seq))
+	start := time.Now()
+	err := conn.WriteJSON(t)
+	if rate != nil {
+		rate.Observe(time.Since(start), err)
+	}
+	if err != nil {
+		slog.Error("Unable to write message", slog.Int("obuid", t.OBUID), slog.Any("err", err))
+		stats.Errors.Add(1)
+		if sp != nil {
+			if err := sp.Append(t); err != nil {
+				slog.Error("Couldn't spool reading", slog.Int("obuid", t.OBUID), slog.Any("err", err))
+			}
+		}
+		return err
+	}
+	stats.Produced.Add(1)
+	return nil
+}
+
+// sendArray is send for several readings, written as one JSON array.
+func sendArray(conn *websocket.Conn, batch []types.SourceCoords, rate *aimd, sp *spool) error {
+	slog.Info("Sending readings", slog.Int("count", len(batch)))
+	start := time.Now()
+	err := conn.WriteJSON(batch)
+	if rate != nil {
+		rate.Observe(time.Since(start), err)
+	}
+	if err != nil {
+		slog.Error("Unable to write readings", slog.Int("count", len(batch)), slog.Any("err", err))
+		stats.Errors.Add(1)
+		if sp != nil {
+			for _, t := range batch {
+				if err := sp.Append(t); err != nil {
+					slog.Error("Couldn't spool reading", slog.Int("obuid", t.OBUID), slog.Any("err", err))
+				}
+			}
+		}
+		return err
+	}
+	stats.Produced.Add(int64(len(batch)))
+	return nil
+}
+
+// closeConn tells the receiver we're going away before closing conn.
+func closeConn(conn *websocket.Conn) {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	if err := conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		slog.Warn("Couldn't send close message", slog.Any("err", err))
 	}
+	conn.Close()
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+func TestWriteLoopStopsOnCancel(t *testing.T) {
+	srv := newFlakyServer(serve)
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial(srv.URL(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q := make(fifo, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writeLoop(ctx, conn, srv.URL(), backoff{min: time.Millisecond, max: time.Millisecond, attempts: 1}, q, nil, nil, 1)
+	}()
+	q <- types.SourceCoords{OBUID: 1, Lat: 1, Lon: 1}
+	select {
+	case <-srv.readings:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reading not sent")
+	}
+
+	// With the queue empty, the loop must notice without another reading.
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("writeLoop still running a second after cancel")
+	}
+	select {
+	case err := <-srv.closes:
+		if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			t.Errorf("receiver saw %v, want a normal close", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed")
+	}
+}
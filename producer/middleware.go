@@ -1,14 +1,33 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"time"
+
+	"github.com/erastusk/gpscords/types"
 )
 
-func MiddlewareReceiver(h func() (int, float64, float64)) (int, float64, float64) {
-	start := time.Now()
-	defer func() {
-		log.Println("Took", time.Since(start))
-	}()
-	return h()
+// Source generates the next reading to send.
+type Source func() types.SourceCoords
+
+// Middleware wraps a Source, for example to time or adjust its readings.
+type Middleware func(next Source) Source
+
+// Chain wraps s in mws so that they run in order before s.
+func Chain(s Source, mws ...Middleware) Source {
+	for i := len(mws) - 1; i >= 0; i-- {
+		s = mws[i](s)
+	}
+	return s
+}
+
+// Timing logs how long the rest of the chain took to generate a reading.
+func Timing(next Source) Source {
+	return func() types.SourceCoords {
+		start := time.Now()
+		defer func() {
+			slog.Debug("Generated reading", slog.Duration("took", time.Since(start)))
+		}()
+		return next()
+	}
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+func TestChain(t *testing.T) {
+	var ran []string
+	// tag returns a middleware noting name as it runs and adding one to
+	// the reading's Seq on the way out.
+	tag := func(name string) Middleware {
+		return func(next Source) Source {
+			return func() types.SourceCoords {
+				ran = append(ran, name)
+				r := next()
+				r.Seq++
+				return r
+			}
+		}
+	}
+	source := func() types.SourceCoords {
+		ran = append(ran, "source")
+		return types.SourceCoords{OBUID: 1}
+	}
+	got := Chain(source, tag("a"), tag("b"), Timing)()
+	if want := []string{"a", "b", "source"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+	if got.OBUID != 1 || got.Seq != 2 {
+		t.Errorf("got %+v, want OBU 1's reading passed through both middlewares", got)
+	}
+}
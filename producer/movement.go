@@ -0,0 +1,37 @@
+package main
+
+import (
+	"math/rand"
+
+	"github.com/erastusk/gpscords/geo"
+)
+
+// walker moves simulated OBUs by a random walk: each reading is a step of at
+// most maxStep meters in a random direction from the OBU's previous
+// position, so derived speeds stay plausible. Great-circle steps keep the
+// coordinates valid, crossing the poles and the antimeridian as needed.
+type walker struct {
+	rng     *rand.Rand
+	maxStep float64
+	pos     map[int][2]float64 // lat, lon
+}
+
+func newWalker(rng *rand.Rand, maxStep float64) *walker {
+	return &walker{rng: rng, maxStep: maxStep, pos: make(map[int][2]float64)}
+}
+
+// Step returns the next position of obuid. An OBU seen for the first time
+// starts at a random position. With remember unset the position is not
+// kept, which suits fleets of throwaway OBUs.
+func (w *walker) Step(obuid int, remember bool) (float64, float64) {
+	p, ok := w.pos[obuid]
+	if !ok {
+		p = [2]float64{w.rng.Float64()*160 - 80, w.rng.Float64()*360 - 180}
+	} else {
+		p[0], p[1] = geo.Destination(p[0], p[1], w.rng.Float64()*360, w.rng.Float64()*w.maxStep)
+	}
+	if remember {
+		w.pos[obuid] = p
+	}
+	return p[0], p[1]
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/erastusk/gpscords/geo"
+)
+
+func TestWalkerStepsAreBounded(t *testing.T) {
+	tests := []struct {
+		name  string
+		start [2]float64 // zero for a random start
+	}{
+		{"random start", [2]float64{}},
+		{"near the north pole", [2]float64{89.9999, 0}},
+		{"on the antimeridian", [2]float64{0, 179.9999}},
+	}
+	const maxStep = 50.0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newWalker(rand.New(rand.NewSource(1)), maxStep)
+			if tt.start != ([2]float64{}) {
+				w.pos[1] = tt.start
+			}
+			lat, lon := w.Step(1, true)
+			for i := 0; i < 1000; i++ {
+				nlat, nlon := w.Step(1, true)
+				if d := geo.Haversine(lat, lon, nlat, nlon); d > maxStep+1e-6 {
+					t.Fatalf("step %d moved %.2fm, want at most %vm", i, d, maxStep)
+				}
+				if math.Abs(nlat) > 90 || math.Abs(nlon) > 180 {
+					t.Fatalf("step %d left the globe at %v, %v", i, nlat, nlon)
+				}
+				lat, lon = nlat, nlon
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+// queue hands readings from the generator to the WebSocket writer.
+type queue interface {
+	Push(types.SourceCoords)
+	// Pop blocks until a reading is available or ctx is done. It reports
+	// false in the latter case.
+	Pop(ctx context.Context) (types.SourceCoords, bool)
+	// TryPop is Pop without blocking: it reports false when no reading is
+	// waiting.
+	TryPop() (types.SourceCoords, bool)
+}
+
+// fifo forwards every reading in the order it was generated.
+type fifo chan types.SourceCoords
+
+func (q fifo) Push(t types.SourceCoords) { q <- t }
+
+func (q fifo) Pop(ctx context.Context) (types.SourceCoords, bool) {
+	select {
+	case t := <-q:
+		return t, true
+	case <-ctx.Done():
+		return types.SourceCoords{}, false
+	}
+}
+
+func (q fifo) TryPop() (types.SourceCoords, bool) {
+	select {
+	case t := <-q:
+		return t, true
+	default:
+		return types.SourceCoords{}, false
+	}
+}
+
+// coalescer keeps at most one pending reading per OBU. A newer reading for an
+// OBU that is still waiting replaces the old one in place, so OBUs are drained
+// in the order they first became pending.
+type coalescer struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	order   []int
+	pending map[int]types.SourceCoords
+}
+
+func newCoalescer() *coalescer {
+	q := &coalescer{pending: make(map[int]types.SourceCoords)}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *coalescer) Push(t types.SourceCoords) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.pending[t.OBUID]; !ok {
+		q.order = append(q.order, t.OBUID)
+	}
+	q.pending[t.OBUID] = t
+	q.cond.Signal()
+}
+
+func (q *coalescer) Pop(ctx context.Context) (types.SourceCoords, bool) {
+	stop := context.AfterFunc(ctx, func() {
+		q.mu.Lock()
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	})
+	defer stop()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.order) == 0 {
+		if ctx.Err() != nil {
+			return types.SourceCoords{}, false
+		}
+		q.cond.Wait()
+	}
+	return q.popLocked(), true
+}
+
+func (q *coalescer) TryPop() (types.SourceCoords, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.order) == 0 {
+		return types.SourceCoords{}, false
+	}
+	return q.popLocked(), true
+}
+
+// popLocked removes the oldest pending reading. q.mu must be held and a
+// reading pending.
+func (q *coalescer) popLocked() types.SourceCoords {
+	id := q.order[0]
+	q.order = q.order[1:]
+	t := q.pending[id]
+	delete(q.pending, id)
+	return t
+}
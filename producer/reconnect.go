@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// backoff is the retry schedule for dialing the receiver. The delay starts at
+// min and doubles after every failed dial, up to max. attempts bounds the
+// number of dials; zero retries forever.
+type backoff struct {
+	min, max time.Duration
+	attempts int
+}
+
+// dial connects to endpoint, retrying on failure with a schedule of b. It
+// gives up once ctx is done or b.attempts dials have failed.
+func (b backoff) dial(ctx context.Context, endpoint string) (*websocket.Conn, error) {
+	delay := b.min
+	for attempt := 1; ; attempt++ {
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, endpoint, nil)
+		if err == nil {
+			go discardReads(conn)
+			return conn, nil
+		}
+		if b.attempts > 0 && attempt >= b.attempts {
+			return nil, fmt.Errorf("dialing %s: giving up after %d attempts: %w", endpoint, attempt, err)
+		}
+		slog.Warn("Couldn't dial receiver, retrying", slog.String("endpoint", endpoint), slog.Duration("delay", delay), slog.Any("err", err))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay = min(delay*2, b.max)
+	}
+}
+
+// discardReads reads from conn until it fails. The producer never expects
+// messages, but reading is what answers the receiver's pings and handles its
+// close frame.
+func discardReads(conn *websocket.Conn) {
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
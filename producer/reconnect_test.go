@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/erastusk/gpscords/config"
+	"github.com/erastusk/gpscords/types"
+)
+
+// step is how flakyServer answers a dial.
+type step int
+
+const (
+	refuse step = iota // fail the handshake
+	drop               // accept, then drop the connection after one reading
+	serve              // accept and read readings until the connection closes
+)
+
+// received is a reading and the dial, counted from 1, whose connection
+// carried it.
+type received struct {
+	dial int
+	t    types.SourceCoords
+}
+
+// flakyServer is a receiver answering the nth dial with script[n-1],
+// repeating the last step once the script runs out.
+type flakyServer struct {
+	*httptest.Server
+	script   []step
+	readings chan received
+	// closes gets the error that ended each served connection.
+	closes chan error
+
+	mu     sync.Mutex
+	dialed []time.Time
+}
+
+func newFlakyServer(script ...step) *flakyServer {
+	s := &flakyServer{script: script, readings: make(chan received, 1000), closes: make(chan error, 100)}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+func (s *flakyServer) URL() string {
+	return "ws" + strings.TrimPrefix(s.Server.URL, "http")
+}
+
+// dials returns when each dial so far arrived.
+func (s *flakyServer) dials() []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]time.Time(nil), s.dialed...)
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.dialed = append(s.dialed, time.Now())
+	dial := len(s.dialed)
+	st := s.script[min(dial, len(s.script))-1]
+	s.mu.Unlock()
+	if st == refuse {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var upgrader websocket.Upgrader
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		var t types.SourceCoords
+		if err := conn.ReadJSON(&t); err != nil {
+			select {
+			case s.closes <- err:
+			default:
+			}
+			return
+		}
+		select {
+		case s.readings <- received{dial, t}:
+		default:
+		}
+		if st == drop {
+			return
+		}
+	}
+}
+
+func TestWriteLoopReconnects(t *testing.T) {
+	srv := newFlakyServer(drop, refuse, refuse, serve)
+	defer srv.Close()
+	retry := backoff{min: 20 * time.Millisecond, max: 40 * time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn, err := retry.dial(ctx, srv.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := make(fifo, 100)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writeLoop(ctx, conn, srv.URL(), retry, q, nil, nil, 1)
+	}()
+	// Keep generating, as the writer only notices the dropped connection
+	// when a write fails.
+	go func() {
+		tick := time.NewTicker(5 * time.Millisecond)
+		defer tick.Stop()
+		for obuid := 1; ; obuid++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+			}
+			select {
+			case q <- types.SourceCoords{OBUID: obuid, Lat: 1, Lon: 1}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for resumed := false; !resumed; {
+		select {
+		case r := <-srv.readings:
+			resumed = r.dial == 4
+		case <-timeout:
+			t.Fatalf("producer didn't resume sending, %d dials so far", len(srv.dials()))
+		}
+	}
+	cancel()
+	<-done
+
+	dials := srv.dials()
+	if len(dials) != 4 {
+		t.Fatalf("got %d dials, want 4", len(dials))
+	}
+	// The first redial is immediate, then each failure doubles the delay.
+	for i, want := range []time.Duration{retry.min, retry.min * 2} {
+		gap := dials[i+2].Sub(dials[i+1])
+		if gap < want || gap > want+time.Second {
+			t.Errorf("redial %d came %v after the previous one, want %v to %v", i+2, gap, want, want+time.Second)
+		}
+	}
+}
+
+func TestDialFailure(t *testing.T) {
+	refusing := newFlakyServer(refuse)
+	defer refusing.Close()
+	// Nothing listens at a closed server's address.
+	closed := newFlakyServer(serve)
+	closed.Close()
+
+	giveUp := backoff{min: time.Millisecond, max: 2 * time.Millisecond, attempts: 3}
+	forever := backoff{min: 10 * time.Millisecond, max: 10 * time.Millisecond}
+	tests := []struct {
+		name    string
+		srv     *flakyServer
+		b       backoff
+		timeout time.Duration
+		wantErr error
+		// wantDials is how many dials srv should see, when it is listening.
+		wantDials int
+	}{
+		{"handshake refused, giving up", refusing, giveUp, time.Minute, nil, 3},
+		{"connection refused, giving up", closed, giveUp, time.Minute, nil, 0},
+		{"handshake refused, retrying until cancelled", refusing, forever, 50 * time.Millisecond, context.DeadlineExceeded, 0},
+		{"connection refused, retrying until cancelled", closed, forever, 50 * time.Millisecond, context.DeadlineExceeded, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			before := len(tt.srv.dials())
+			conn, err := tt.b.dial(ctx, tt.srv.URL())
+			if conn != nil {
+				conn.Close()
+				t.Fatal("dial returned a connection")
+			}
+			if err == nil {
+				t.Fatal("dial succeeded")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("dial error = %v, want %v", err, tt.wantErr)
+			}
+			if n := len(tt.srv.dials()) - before; tt.wantDials > 0 && n != tt.wantDials {
+				t.Errorf("got %d dials, want %d", n, tt.wantDials)
+			}
+		})
+	}
+}
+
+func TestDialsConfiguredEndpoint(t *testing.T) {
+	paths := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+		var upgrader websocket.Upgrader
+		if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+	defer func(endpoint string) { *wsEndpoint = endpoint }(*wsEndpoint)
+	t.Setenv("PRODUCER_ENDPOINT", "ws"+strings.TrimPrefix(srv.URL, "http")+"/gps/ws")
+	if err := config.Load(flag.CommandLine, "PRODUCER", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := backoff{min: time.Millisecond, max: time.Millisecond, attempts: 1}.dial(context.Background(), *wsEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if got := <-paths; got != "/gps/ws" {
+		t.Errorf("dialed %s, want /gps/ws", got)
+	}
+}
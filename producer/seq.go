@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// sequencer hands out per-OBU sequence numbers, starting at 1. With a state
+// file the last number issued per OBU survives restarts, so consumers don't
+// see the sequence reset.
+type sequencer struct {
+	mu   sync.Mutex
+	path string
+	last map[int]uint64
+}
+
+// newSequencer resumes from the state file at path, if any. A missing file
+// starts every OBU from scratch; so does a corrupt one, with a warning.
+func newSequencer(path string) *sequencer {
+	s := &sequencer{path: path, last: make(map[int]uint64)}
+	if path == "" {
+		return s
+	}
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		slog.Warn("Couldn't read sequence state, starting from scratch", slog.String("path", path), slog.Any("err", err))
+	default:
+		if err := json.Unmarshal(b, &s.last); err != nil {
+			slog.Warn("Corrupt sequence state, starting from scratch", slog.String("path", path), slog.Any("err", err))
+			s.last = make(map[int]uint64)
+		}
+	}
+	return s
+}
+
+// Next returns the next sequence number for obuid.
+func (s *sequencer) Next(obuid int) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last[obuid]++
+	return s.last[obuid]
+}
+
+// Save writes the state file, replacing it atomically.
+func (s *sequencer) Save() error {
+	if s.path == "" {
+		return nil
+	}
+	s.mu.Lock()
+	b, err := json.Marshal(s.last)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/erastusk/gpscords/types"
+)
+
+var errSpoolFull = errors.New("spool file full")
+
+// spool keeps readings that couldn't be sent in a JSON-lines file, so they
+// can be replayed later, by this producer or a separate tool. The file never
+// grows past maxBytes; readings that don't fit are dropped.
+type spool struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+}
+
+func newSpool(path string, maxBytes int64) *spool {
+	return &spool{path: path, maxBytes: maxBytes}
+}
+
+// Append writes t to the end of the spool file.
+func (s *spool) Append(t types.SourceCoords) error {
+	line, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size()+int64(len(line)) > s.maxBytes {
+		return errSpoolFull
+	}
+	_, err = f.Write(line)
+	return err
+}
+
+// Replay sends the spooled readings over conn in order. Whatever couldn't be
+// sent stays in the file. It returns the number of readings sent.
+func (s *spool) Replay(conn *websocket.Conn) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	var rest bytes.Buffer
+	var sendErr error
+	for sc.Scan() {
+		line := sc.Bytes()
+		if sendErr == nil {
+			var t types.SourceCoords
+			if json.Unmarshal(line, &t) != nil {
+				continue
+			}
+			if sendErr = conn.WriteJSON(t); sendErr == nil {
+				sent++
+				continue
+			}
+		}
+		rest.Write(line)
+		rest.WriteByte('\n')
+	}
+	if err := os.WriteFile(s.path, rest.Bytes(), 0o644); err != nil {
+		return sent, err
+	}
+	return sent, sendErr
+}
@@ -0,0 +1,61 @@
+package stats
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Run counters for the current process. Each service only touches the ones
+// that apply to it.
+var (
+	Produced   atomic.Int64
+	Consumed   atomic.Int64
+	Errors     atomic.Int64
+	Reconnects atomic.Int64
+
+	start = time.Now()
+
+	shutdownHooks []func()
+)
+
+// OnShutdown registers f to run on SIGINT/SIGTERM, before the report is
+// logged.
+func OnShutdown(f func()) {
+	shutdownHooks = append(shutdownHooks, f)
+}
+
+// Report logs a single summary of the run so far.
+func Report(service string) {
+	slog.Info("Shutdown report",
+		slog.String("service", service),
+		slog.Duration("uptime", time.Since(start).Round(time.Millisecond)),
+		slog.Int64("produced", Produced.Load()),
+		slog.Int64("consumed", Consumed.Load()),
+		slog.Int64("errors", Errors.Load()),
+		slog.Int64("reconnects", Reconnects.Load()),
+	)
+}
+
+// Shutdown runs the registered shutdown hooks and logs the report.
+func Shutdown(service string) {
+	for _, f := range shutdownHooks {
+		f()
+	}
+	Report(service)
+}
+
+// ReportOnSignal calls Shutdown and exits once the process receives SIGINT
+// or SIGTERM.
+func ReportOnSignal(service string) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		Shutdown(service)
+		os.Exit(0)
+	}()
+}
@@ -0,0 +1,36 @@
+package types
+
+import "encoding/json"
+
+// Codec converts readings to and from the bytes carried in Kafka records.
+type Codec interface {
+	Encode(SourceCoords) ([]byte, error)
+	Decode([]byte) (SourceCoords, error)
+}
+
+// JSONCodec encodes readings as JSON. It is the default.
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(s SourceCoords) ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (JSONCodec) Decode(b []byte) (SourceCoords, error) {
+	var s SourceCoords
+	err := json.Unmarshal(b, &s)
+	return s, err
+}
+
+// ProtoCodec encodes readings as the protobuf message in sourcecoords.proto,
+// which is several times smaller than JSON.
+type ProtoCodec struct{}
+
+func (ProtoCodec) Encode(s SourceCoords) ([]byte, error) {
+	return s.MarshalProto(), nil
+}
+
+func (ProtoCodec) Decode(b []byte) (SourceCoords, error) {
+	var s SourceCoords
+	err := s.UnmarshalProto(b)
+	return s, err
+}
@@ -0,0 +1,48 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJSONCodec(t *testing.T) {
+	reading := SourceCoords{OBUID: 42, Lat: 48.8583701, Lon: 2.2944813, Timestamp: time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC), FwVersion: "2.1.0", Seq: 9}
+	tests := []struct {
+		name    string
+		data    string
+		want    SourceCoords
+		wantErr bool
+	}{
+		{"reading", `{"obuid":42,"lat":48.8583701,"lon":2.2944813,"timestamp":"2024-05-01T13:00:00Z","fw_version":"2.1.0","seq":9}`, reading, false},
+		{"core fields only", `{"obuid":1,"lat":1,"lon":2}`, SourceCoords{OBUID: 1, Lat: 1, Lon: 2}, false},
+		{"unknown field", `{"obuid":1,"lat":1,"lon":2,"alt":300}`, SourceCoords{OBUID: 1, Lat: 1, Lon: 2}, false},
+		{"no obuid", `{"lat":1,"lon":2}`, SourceCoords{}, true},
+		{"wrong type", `{"obuid":"1","lat":1,"lon":2}`, SourceCoords{}, true},
+		{"null", `null`, SourceCoords{}, true},
+		{"array", `[{"obuid":1}]`, SourceCoords{}, true},
+		{"truncated", `{"obuid":1,"lat":1`, SourceCoords{}, true},
+		{"empty", ``, SourceCoords{}, true},
+	}
+	var c Codec = JSONCodec{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Decode([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode(%s) error = %v, want error %v", tt.data, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Decode(%s) = %+v, want %+v", tt.data, got, tt.want)
+			}
+			b, err := c.Encode(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again, err := c.Decode(b); err != nil || again != got {
+				t.Errorf("Decode(Encode(%+v)) = %+v, %v", got, again, err)
+			}
+		})
+	}
+}
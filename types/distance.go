@@ -0,0 +1,15 @@
+package types
+
+import "github.com/erastusk/gpscords/geo"
+
+// DistanceTo returns the great-circle distance in meters from s to other,
+// including across the antimeridian. It is 0 for identical points.
+func (s SourceCoords) DistanceTo(other SourceCoords) float64 {
+	return geo.Haversine(s.Lat, s.Lon, other.Lat, other.Lon)
+}
+
+// Bearing returns the initial heading in degrees clockwise from north, in
+// [0, 360), for travelling from a to b. It is 0 for identical points.
+func Bearing(a, b SourceCoords) float64 {
+	return geo.Bearing(a.Lat, a.Lon, b.Lat, b.Lon)
+}
@@ -0,0 +1,32 @@
+package types
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDistanceToAndBearing(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    SourceCoords
+		km      float64
+		bearing float64
+	}{
+		{"London to Paris", SourceCoords{Lat: 51.5074, Lon: -0.1278}, SourceCoords{Lat: 48.8566, Lon: 2.3522}, 343.5, 148.1},
+		{"New York to Los Angeles", SourceCoords{Lat: 40.7128, Lon: -74.0060}, SourceCoords{Lat: 34.0522, Lon: -118.2437}, 3936, 273.7},
+		{"Suva to Apia, across the antimeridian", SourceCoords{Lat: -18.1248, Lon: 178.4501}, SourceCoords{Lat: -13.8333, Lon: -171.7667}, 1149, 66.9},
+		{"west across the antimeridian", SourceCoords{Lat: 0, Lon: -179.9}, SourceCoords{Lat: 0, Lon: 179.9}, 22.2, 270},
+		{"identical points", SourceCoords{OBUID: 1, Lat: 48.8566, Lon: 2.3522}, SourceCoords{OBUID: 1, Lat: 48.8566, Lon: 2.3522}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			km := tt.a.DistanceTo(tt.b) / 1000
+			if tol := math.Max(0.005*tt.km, 0.1); math.Abs(km-tt.km) > tol {
+				t.Errorf("DistanceTo = %.1f km, want %.1f ± %.1f", km, tt.km, tol)
+			}
+			if b := Bearing(tt.a, tt.b); math.Abs(b-tt.bearing) > 0.5 {
+				t.Errorf("Bearing = %.1f°, want %.1f°", b, tt.bearing)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package types
+
+// Enriched is a reading plus values derived from the same OBU's previous
+// reading. The derived fields are pointers and omitted from JSON when nil:
+// nil means the value couldn't be computed, for example on an OBU's first
+// reading, and must not be read as a measured zero.
+type Enriched struct {
+	SourceCoords
+	Speed   *float64 `json:"speed,omitempty"`   // meters per second
+	Heading *float64 `json:"heading,omitempty"` // degrees clockwise from north
+}
+
+// Enrich derives speed and heading for cur from prev, which may be nil.
+// Speed needs both timestamps and a positive time delta; heading needs the
+// OBU to have moved.
+func Enrich(cur SourceCoords, prev *SourceCoords) Enriched {
+	e := Enriched{SourceCoords: cur}
+	if prev == nil {
+		return e
+	}
+	dist := prev.DistanceTo(cur)
+	if !prev.Timestamp.IsZero() && !cur.Timestamp.IsZero() {
+		if dt := cur.Timestamp.Sub(prev.Timestamp).Seconds(); dt > 0 {
+			speed := dist / dt
+			e.Speed = &speed
+		}
+	}
+	if dist > 0 {
+		heading := Bearing(*prev, cur)
+		e.Heading = &heading
+	}
+	return e
+}
@@ -0,0 +1,48 @@
+package types
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnrichOmitsUncomputableFields(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	first := SourceCoords{OBUID: 1, Lat: 0, Lon: 0, Timestamp: t0}
+	tests := []struct {
+		name             string
+		cur              SourceCoords
+		prev             *SourceCoords
+		speed, heading   bool
+		jsonHas, jsonNot []string
+	}{
+		{"first point", first, nil, false, false, nil, []string{"speed", "heading"}},
+		{"moved", SourceCoords{OBUID: 1, Lat: 0.001, Timestamp: t0.Add(10 * time.Second)}, &first, true, true, []string{`"speed"`, `"heading"`}, nil},
+		{"stationary", SourceCoords{OBUID: 1, Timestamp: t0.Add(10 * time.Second)}, &first, true, false, []string{`"speed":0`}, []string{"heading"}},
+		{"no timestamp", SourceCoords{OBUID: 1, Lat: 0.001}, &first, false, true, []string{`"heading"`}, []string{"speed"}},
+		{"same timestamp", SourceCoords{OBUID: 1, Lat: 0.001, Timestamp: t0}, &first, false, true, nil, []string{"speed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := Enrich(tt.cur, tt.prev)
+			if (e.Speed != nil) != tt.speed || (e.Heading != nil) != tt.heading {
+				t.Errorf("got speed %v and heading %v, want speed set %v and heading set %v", e.Speed, e.Heading, tt.speed, tt.heading)
+			}
+			b, err := json.Marshal(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.jsonHas {
+				if !strings.Contains(string(b), s) {
+					t.Errorf("%s lacks %s", b, s)
+				}
+			}
+			for _, s := range tt.jsonNot {
+				if strings.Contains(string(b), s) {
+					t.Errorf("%s has %s", b, s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package types
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+)
+
+// HeaderExpiresAt is the Kafka record header carrying the instant after
+// which a reading should no longer be processed.
+const HeaderExpiresAt = "expires-at"
+
+// HeaderOBUID carries the decimal OBUID of the reading in a record, so tools
+// can filter records without decoding them.
+const HeaderOBUID = "obuid"
+
+// HeaderBatch marks a record whose value is a JSON array of readings rather
+// than a single one. It carries the decimal number of readings.
+const HeaderBatch = "batch"
+
+// HeaderProducerVersion identifies the build of the service that produced a
+// record.
+const HeaderProducerVersion = "producer-version"
+
+// FormatExpiresAt encodes t as an expires-at header value.
+func FormatExpiresAt(t time.Time) []byte {
+	return []byte(t.UTC().Format(time.RFC3339Nano))
+}
+
+// ParseExpiresAt decodes an expires-at header value. RFC3339 timestamps are
+// preferred, but unix epoch milliseconds are accepted too.
+func ParseExpiresAt(v []byte) (time.Time, error) {
+	s := string(bytes.TrimSpace(v))
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err == nil {
+		return t, nil
+	}
+	ms, perr := strconv.ParseInt(s, 10, 64)
+	if perr != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(ms), nil
+}
@@ -0,0 +1,111 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// wireCoords is the JSON form of SourceCoords; see there for the contract.
+// Field order here is the order on the wire.
+type wireCoords struct {
+	OBUID     *int       `json:"obuid"`
+	Lat       float64    `json:"lat"`
+	Lon       float64    `json:"lon"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	FwVersion string     `json:"fw_version,omitempty"`
+	Seq       uint64     `json:"seq,omitempty"`
+	Topic     string     `json:"topic,omitempty"`
+}
+
+func (s SourceCoords) wire() wireCoords {
+	w := wireCoords{
+		OBUID:     &s.OBUID,
+		Lat:       s.Lat,
+		Lon:       s.Lon,
+		FwVersion: s.FwVersion,
+		Seq:       s.Seq,
+		Topic:     s.Topic,
+	}
+	if !s.Timestamp.IsZero() {
+		w.Timestamp = &s.Timestamp
+	}
+	return w
+}
+
+func (w wireCoords) coords() (SourceCoords, error) {
+	if w.OBUID == nil {
+		return SourceCoords{}, errors.New("reading has no obuid")
+	}
+	s := SourceCoords{
+		OBUID:     *w.OBUID,
+		Lat:       w.Lat,
+		Lon:       w.Lon,
+		FwVersion: w.FwVersion,
+		Seq:       w.Seq,
+		Topic:     w.Topic,
+	}
+	if w.Timestamp != nil {
+		s.Timestamp = *w.Timestamp
+	}
+	return s, nil
+}
+
+func (s SourceCoords) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.wire())
+}
+
+// UnmarshalJSON accepts any JSON object with an obuid, leaving missing
+// optional fields zero. null, non-objects and fields of the wrong type are
+// rejected.
+func (s *SourceCoords) UnmarshalJSON(b []byte) error {
+	var w wireCoords
+	if err := unmarshalObject(b, &w); err != nil {
+		return err
+	}
+	c, err := w.coords()
+	if err != nil {
+		return err
+	}
+	*s = c
+	return nil
+}
+
+// wireEnriched is the JSON form of Enriched: the reading's fields followed by
+// the derived ones. Without its own methods Enriched would marshal through
+// the promoted SourceCoords ones and lose them.
+type wireEnriched struct {
+	wireCoords
+	Speed   *float64 `json:"speed,omitempty"`
+	Heading *float64 `json:"heading,omitempty"`
+}
+
+func (e Enriched) MarshalJSON() ([]byte, error) {
+	return json.Marshal(wireEnriched{e.SourceCoords.wire(), e.Speed, e.Heading})
+}
+
+func (e *Enriched) UnmarshalJSON(b []byte) error {
+	var w wireEnriched
+	if err := unmarshalObject(b, &w); err != nil {
+		return err
+	}
+	c, err := w.coords()
+	if err != nil {
+		return err
+	}
+	*e = Enriched{SourceCoords: c, Speed: w.Speed, Heading: w.Heading}
+	return nil
+}
+
+// unmarshalObject is json.Unmarshal, except that it rejects anything but an
+// object rather than silently leaving v unset for null.
+func unmarshalObject(b []byte, v any) error {
+	var raw json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if len(raw) == 0 || raw[0] != '{' {
+		return errors.New("reading isn't a JSON object")
+	}
+	return json.Unmarshal(raw, v)
+}
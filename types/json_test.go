@@ -0,0 +1,148 @@
+package types
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFwVersionIsOptional(t *testing.T) {
+	tests := []struct {
+		name string
+		in   SourceCoords
+	}{
+		{"with version", SourceCoords{OBUID: 1, Lat: 1, Lon: 2, FwVersion: "2.1.0-rc1"}},
+		{"without version", SourceCoords{OBUID: 1, Lat: 1, Lon: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if has := strings.Contains(string(b), "fw_version"); has != (tt.in.FwVersion != "") {
+				t.Errorf("%s: fw_version present %v, want %v", b, has, tt.in.FwVersion != "")
+			}
+			var fromJSON SourceCoords
+			if err := json.Unmarshal(b, &fromJSON); err != nil {
+				t.Fatal(err)
+			}
+			var fromProto SourceCoords
+			if err := fromProto.UnmarshalProto(tt.in.MarshalProto()); err != nil {
+				t.Fatal(err)
+			}
+			for codec, got := range map[string]SourceCoords{"json": fromJSON, "protobuf": fromProto} {
+				if got != tt.in {
+					t.Errorf("%s round trip = %+v, want %+v", codec, got, tt.in)
+				}
+			}
+		})
+	}
+}
+
+func TestTimestampRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		ts   time.Time
+	}{
+		{"utc", time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)},
+		{"nanoseconds", time.Date(2024, 5, 1, 13, 0, 0, 123456789, time.UTC)},
+		{"offset zone", time.Date(2024, 5, 1, 15, 0, 0, 0, time.FixedZone("CEST", 2*60*60))},
+		{"zero", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := SourceCoords{OBUID: 1, Lat: 1, Lon: 2, Timestamp: tt.ts}
+			b, err := json.Marshal(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if has := strings.Contains(string(b), "timestamp"); has == tt.ts.IsZero() {
+				t.Errorf("%s: timestamp present %v, want %v", b, has, !tt.ts.IsZero())
+			}
+			var fromJSON SourceCoords
+			if err := json.Unmarshal(b, &fromJSON); err != nil {
+				t.Fatal(err)
+			}
+			var fromProto SourceCoords
+			if err := fromProto.UnmarshalProto(in.MarshalProto()); err != nil {
+				t.Fatal(err)
+			}
+			for codec, got := range map[string]SourceCoords{"json": fromJSON, "protobuf": fromProto} {
+				if !got.Timestamp.Equal(tt.ts) {
+					t.Errorf("%s round trip timestamp = %v, want %v", codec, got.Timestamp, tt.ts)
+				}
+			}
+		})
+	}
+}
+
+func TestMissingTimestampIsZero(t *testing.T) {
+	var s SourceCoords
+	if err := json.Unmarshal([]byte(`{"obuid":1,"lat":1,"lon":2}`), &s); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Timestamp.IsZero() {
+		t.Errorf("timestamp = %v, want zero", s.Timestamp)
+	}
+}
+
+func TestWireContract(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)
+	speed, heading := 12.5, 90.0
+	tests := []struct {
+		name string
+		in   any
+		want string
+	}{
+		{"core fields only", SourceCoords{OBUID: 1, Lat: 1.5, Lon: 2}, `{"obuid":1,"lat":1.5,"lon":2}`},
+		{"zero core fields", SourceCoords{}, `{"obuid":0,"lat":0,"lon":0}`},
+		{"every optional", SourceCoords{OBUID: 1, Lat: 1.5, Lon: 2, Timestamp: t0, FwVersion: "2.1.0", Seq: 7, Topic: "gps"},
+			`{"obuid":1,"lat":1.5,"lon":2,"timestamp":"2024-05-01T13:00:00Z","fw_version":"2.1.0","seq":7,"topic":"gps"}`},
+		{"some optionals", SourceCoords{OBUID: 1, Lat: 1.5, Lon: 2, Seq: 7}, `{"obuid":1,"lat":1.5,"lon":2,"seq":7}`},
+		{"enriched", Enriched{SourceCoords: SourceCoords{OBUID: 1, Lat: 1.5, Lon: 2, Timestamp: t0}, Speed: &speed, Heading: &heading},
+			`{"obuid":1,"lat":1.5,"lon":2,"timestamp":"2024-05-01T13:00:00Z","speed":12.5,"heading":90}`},
+		{"enriched without derived fields", Enriched{SourceCoords: SourceCoords{OBUID: 1, Lat: 1.5, Lon: 2}}, `{"obuid":1,"lat":1.5,"lon":2}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("Marshal = %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestOldPayloadsDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    SourceCoords
+		wantErr bool
+	}{
+		{"original payload", `{"obuid":7,"lat":48.85,"lon":2.29}`, SourceCoords{OBUID: 7, Lat: 48.85, Lon: 2.29}, false},
+		{"keys reordered", `{"lon":2.29,"lat":48.85,"obuid":7}`, SourceCoords{OBUID: 7, Lat: 48.85, Lon: 2.29}, false},
+		{"null optional", `{"obuid":7,"lat":1,"lon":2,"timestamp":null}`, SourceCoords{OBUID: 7, Lat: 1, Lon: 2}, false},
+		{"bad timestamp", `{"obuid":7,"lat":1,"lon":2,"timestamp":"yesterday"}`, SourceCoords{}, true},
+		{"string lat", `{"obuid":7,"lat":"1","lon":2}`, SourceCoords{}, true},
+		{"no obuid", `{"lat":1,"lon":2}`, SourceCoords{}, true},
+		{"null", `null`, SourceCoords{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got SourceCoords
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, want error %v", tt.data, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Unmarshal(%s) = %+v, want %+v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers from sourcecoords.proto.
+const (
+	protoOBUID     protowire.Number = 1
+	protoLat       protowire.Number = 2
+	protoLon       protowire.Number = 3
+	protoTimestamp protowire.Number = 4
+	protoFwVersion protowire.Number = 5
+	protoSeq       protowire.Number = 6
+)
+
+// MarshalProto encodes s as a gpscords.SourceCoords protobuf message.
+func (s SourceCoords) MarshalProto() []byte {
+	var b []byte
+	if s.OBUID != 0 {
+		b = protowire.AppendTag(b, protoOBUID, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(s.OBUID))
+	}
+	if s.Lat != 0 {
+		b = protowire.AppendTag(b, protoLat, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(s.Lat))
+	}
+	if s.Lon != 0 {
+		b = protowire.AppendTag(b, protoLon, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(s.Lon))
+	}
+	if !s.Timestamp.IsZero() {
+		b = protowire.AppendTag(b, protoTimestamp, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(s.Timestamp.UnixNano()))
+	}
+	if s.FwVersion != "" {
+		b = protowire.AppendTag(b, protoFwVersion, protowire.BytesType)
+		b = protowire.AppendString(b, s.FwVersion)
+	}
+	if s.Seq != 0 {
+		b = protowire.AppendTag(b, protoSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, s.Seq)
+	}
+	return b
+}
+
+// UnmarshalProto decodes a gpscords.SourceCoords protobuf message into s.
+// Unknown fields are skipped.
+func (s *SourceCoords) UnmarshalProto(b []byte) error {
+	*s = SourceCoords{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("proto: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch {
+		case num == protoOBUID && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return fmt.Errorf("proto obuid: %w", protowire.ParseError(n))
+			}
+			s.OBUID = int(int64(v))
+			b = b[n:]
+		case (num == protoLat || num == protoLon) && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return fmt.Errorf("proto coordinate: %w", protowire.ParseError(n))
+			}
+			if num == protoLat {
+				s.Lat = math.Float64frombits(v)
+			} else {
+				s.Lon = math.Float64frombits(v)
+			}
+			b = b[n:]
+		case num == protoTimestamp && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return fmt.Errorf("proto timestamp: %w", protowire.ParseError(n))
+			}
+			if v != 0 {
+				s.Timestamp = time.Unix(0, int64(v)).UTC()
+			}
+			b = b[n:]
+		case num == protoFwVersion && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return fmt.Errorf("proto fw_version: %w", protowire.ParseError(n))
+			}
+			s.FwVersion = v
+			b = b[n:]
+		case num == protoSeq && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return fmt.Errorf("proto seq: %w", protowire.ParseError(n))
+			}
+			s.Seq = v
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return fmt.Errorf("proto field %d: %w", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package types
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var benchReading = SourceCoords{OBUID: 4242, Lat: 48.8583701, Lon: 2.2944813, Timestamp: time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC), FwVersion: "2.1.0", Seq: 1234}
+
+func TestProtoRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   SourceCoords
+	}{
+		{"full reading", benchReading},
+		{"zero value", SourceCoords{}},
+		{"negative obuid", SourceCoords{OBUID: -1}},
+		{"extremes", SourceCoords{OBUID: math.MaxInt, Lat: -90, Lon: 180, Seq: math.MaxUint64}},
+		{"smallest coordinate", SourceCoords{OBUID: 1, Lat: math.SmallestNonzeroFloat64}},
+	}
+	var c Codec = ProtoCodec{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := c.Encode(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.Decode(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.in {
+				t.Errorf("round trip = %+v, want %+v", got, tt.in)
+			}
+		})
+	}
+}
+
+func TestProtoSkipsUnknownFields(t *testing.T) {
+	b := protowire.AppendTag(nil, 99, protowire.BytesType)
+	b = protowire.AppendString(b, "from a newer producer")
+	b = append(b, benchReading.MarshalProto()...)
+	got, err := ProtoCodec{}.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != benchReading {
+		t.Errorf("Decode = %+v, want %+v", got, benchReading)
+	}
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	for _, bc := range []struct {
+		name  string
+		codec Codec
+	}{
+		{"json", JSONCodec{}},
+		{"protobuf", ProtoCodec{}},
+	} {
+		data, err := bc.codec.Encode(benchReading)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(bc.name+"/encode", func(b *testing.B) {
+			b.ReportAllocs()
+			b.ReportMetric(float64(len(data)), "encoded-bytes")
+			for i := 0; i < b.N; i++ {
+				if _, err := bc.codec.Encode(benchReading); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(bc.name+"/decode", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := bc.codec.Decode(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
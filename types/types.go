@@ -1,7 +1,35 @@
 package types
 
+import "time"
+
+// DefaultHeartbeatOBUID is the sentinel OBUID the producer gives heartbeat
+// readings unless configured otherwise. Heartbeats carry no position and
+// only show that the pipeline is alive. The sentinel is negative so it can
+// never be a real OBU, nor a record missing its obuid, which decodes as zero.
+// The receiver and reader only recognise heartbeats when configured with it.
+const DefaultHeartbeatOBUID = -1
+
+// SourceCoords is a single position report from an OBU.
+//
+// Its JSON form is a stable contract. obuid, lat and lon are always present,
+// in that order. Every other field is optional: it follows them, in the
+// order declared here, and is omitted while zero. Fields added later are
+// optional too, so records written before they existed still decode.
+// Decoding requires an obuid and tolerates any other field being missing.
 type SourceCoords struct {
-	OBUID int     `json:"obuid"`
-	Lat   float64 `json:"lat"`
-	Lon   float64 `json:"lon"`
+	OBUID     int       `json:"obuid"`
+	Lat       float64   `json:"lat"`
+	Lon       float64   `json:"lon"`
+	Timestamp time.Time `json:"timestamp"`
+	// FwVersion identifies the OBU firmware, so consumers can tell payloads
+	// from different firmware apart. Empty when unknown.
+	FwVersion string `json:"fw_version,omitempty"`
+	// Seq numbers an OBU's readings consecutively from 1, so consumers can
+	// spot gaps. Zero means the producer doesn't sequence readings.
+	Seq uint64 `json:"seq,omitempty"`
+	// Topic is the Kafka topic the reading was consumed from, set by the
+	// reader when it tags readings. Producers leave it empty. The JSON form
+	// carries it, so the reader's JSON output shows the tag; the protobuf
+	// and Avro forms don't.
+	Topic string `json:"topic,omitempty"`
 }
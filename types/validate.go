@@ -0,0 +1,66 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// MaxFutureSkew is how far ahead of the local clock a reading's timestamp may
+// be before Validate rejects it. It allows for OBU clocks running a little
+// fast.
+var MaxFutureSkew = 5 * time.Minute
+
+// Validate reports the first problem that makes s unusable downstream: a
+// non-positive OBUID, coordinates that aren't finite or lie outside
+// -90..90 latitude and -180..180 longitude, or a timestamp more than
+// MaxFutureSkew in the future. A missing timestamp is accepted. Heartbeats
+// use a sentinel OBUID and are checked with ValidateHeartbeat instead.
+func (s SourceCoords) Validate() error {
+	if s.OBUID <= 0 {
+		return fmt.Errorf("obuid %d is not positive", s.OBUID)
+	}
+	if err := s.validateCoords(); err != nil {
+		return err
+	}
+	return s.validateTimestamp()
+}
+
+// ValidateHeartbeat is Validate for a heartbeat, whose OBUID is a sentinel
+// rather than a real OBU. A heartbeat usually has no position, so zero
+// coordinates are accepted, but any others must be valid, and the timestamp
+// is checked as usual.
+func (s SourceCoords) ValidateHeartbeat() error {
+	if s.Lat != 0 || s.Lon != 0 {
+		if err := s.validateCoords(); err != nil {
+			return err
+		}
+	}
+	return s.validateTimestamp()
+}
+
+func (s SourceCoords) validateCoords() error {
+	if err := inRange("lat", s.Lat, 90); err != nil {
+		return err
+	}
+	return inRange("lon", s.Lon, 180)
+}
+
+func (s SourceCoords) validateTimestamp() error {
+	if limit := time.Now().Add(MaxFutureSkew); s.Timestamp.After(limit) {
+		return fmt.Errorf("timestamp %v is more than %v in the future", s.Timestamp.Format(time.RFC3339), MaxFutureSkew)
+	}
+	return nil
+}
+
+// inRange rejects values outside -limit..limit, as well as NaN and ±Inf,
+// which break distance math and can't be marshaled to JSON.
+func inRange(name string, v, limit float64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("%s is %v", name, v)
+	}
+	if v < -limit || v > limit {
+		return fmt.Errorf("%s %v outside -%v..%v", name, v, limit, limit)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package types
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		s       SourceCoords
+		wantErr bool
+	}{
+		{"valid", SourceCoords{OBUID: 1, Lat: 40.7, Lon: -74}, false},
+		{"zero obuid", SourceCoords{OBUID: 0, Lat: 1, Lon: 1}, true},
+		{"negative obuid", SourceCoords{OBUID: -1, Lat: 1, Lon: 1}, true},
+		{"lat 90", SourceCoords{OBUID: 1, Lat: 90}, false},
+		{"lat -90", SourceCoords{OBUID: 1, Lat: -90}, false},
+		{"lat above 90", SourceCoords{OBUID: 1, Lat: math.Nextafter(90, 91)}, true},
+		{"lat below -90", SourceCoords{OBUID: 1, Lat: math.Nextafter(-90, -91)}, true},
+		{"lon 180", SourceCoords{OBUID: 1, Lon: 180}, false},
+		{"lon -180", SourceCoords{OBUID: 1, Lon: -180}, false},
+		{"lon above 180", SourceCoords{OBUID: 1, Lon: math.Nextafter(180, 181)}, true},
+		{"lon below -180", SourceCoords{OBUID: 1, Lon: math.Nextafter(-180, -181)}, true},
+		{"lat NaN", SourceCoords{OBUID: 1, Lat: math.NaN()}, true},
+		{"lon +Inf", SourceCoords{OBUID: 1, Lon: math.Inf(1)}, true},
+		{"lon -Inf", SourceCoords{OBUID: 1, Lon: math.Inf(-1)}, true},
+		{"no timestamp", SourceCoords{OBUID: 1}, false},
+		{"past timestamp", SourceCoords{OBUID: 1, Timestamp: now.Add(-24 * time.Hour)}, false},
+		{"within skew", SourceCoords{OBUID: 1, Timestamp: now.Add(MaxFutureSkew - time.Minute)}, false},
+		{"beyond skew", SourceCoords{OBUID: 1, Timestamp: now.Add(MaxFutureSkew + time.Minute)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.s.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate(%+v) = %v, want error %v", tt.s, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateHeartbeat(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		s       SourceCoords
+		wantErr bool
+	}{
+		{"no position", SourceCoords{OBUID: DefaultHeartbeatOBUID, Timestamp: now}, false},
+		{"no timestamp", SourceCoords{OBUID: DefaultHeartbeatOBUID}, false},
+		{"valid position", SourceCoords{OBUID: DefaultHeartbeatOBUID, Lat: -33.9, Lon: 151.2}, false},
+		{"lat out of range", SourceCoords{OBUID: DefaultHeartbeatOBUID, Lat: 95}, true},
+		{"lon out of range", SourceCoords{OBUID: DefaultHeartbeatOBUID, Lon: -181}, true},
+		{"lat NaN", SourceCoords{OBUID: DefaultHeartbeatOBUID, Lat: math.NaN()}, true},
+		{"beyond skew", SourceCoords{OBUID: DefaultHeartbeatOBUID, Timestamp: now.Add(MaxFutureSkew + time.Minute)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.s.ValidateHeartbeat(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateHeartbeat(%+v) = %v, want error %v", tt.s, err, tt.wantErr)
+			}
+		})
+	}
+}